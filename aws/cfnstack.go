@@ -3,13 +3,16 @@ package aws
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	cloudformationtypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
 )
 
 // Check if a Cfn stack exists
@@ -45,19 +48,40 @@ func stackStatus(ctx context.Context, cf *cloudformation.Client, stackName strin
 
 // Deploys the given CloudFormation YAML template to AWS
 func StackDeploy(ctx context.Context, stackName string, templateBody string) error {
-	cfg, err := config.LoadDefaultConfig(ctx)
+	return StackDeployInRegion(ctx, "", stackName, templateBody)
+}
+
+// Deploys the given CloudFormation YAML template to another region than the default one, or to the default one when
+// the region is empty
+func StackDeployInRegion(ctx context.Context, region string, stackName string, templateBody string) error {
+	return deployStack(ctx, region, stackName, templateBody, nil)
+}
+
+// Deploys the given CloudFormation YAML template, passing values to its parameters by name
+func StackDeployWithParameters(ctx context.Context, stackName string, templateBody string, parameters map[string]string) error {
+	return deployStack(ctx, "", stackName, templateBody, parameters)
+}
+
+func deployStack(ctx context.Context, region string, stackName string, templateBody string, parameters map[string]string) error {
+	options := []func(*config.LoadOptions) error{}
+	if region != "" {
+		options = append(options, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
 	if err != nil {
 		log.Fatalf("failed to load AWS config: %v", err)
 	}
 	cf := cloudformation.NewFromConfig(cfg)
 
 	stackExists := stackExists(ctx, cf, stackName)
+	started := time.Now()
 
 	if stackExists {
 		// Try to update the stack
 		_, err := cf.UpdateStack(ctx, &cloudformation.UpdateStackInput{
 			StackName:    &stackName,
 			TemplateBody: &templateBody,
+			Parameters:   stackParameters(parameters),
 			Capabilities: []cloudformationtypes.Capability{
 				cloudformationtypes.CapabilityCapabilityIam,
 				cloudformationtypes.CapabilityCapabilityNamedIam,
@@ -78,6 +102,7 @@ func StackDeploy(ctx context.Context, stackName string, templateBody string) err
 		_, err := cf.CreateStack(ctx, &cloudformation.CreateStackInput{
 			StackName:    &stackName,
 			TemplateBody: &templateBody,
+			Parameters:   stackParameters(parameters),
 			Capabilities: []cloudformationtypes.Capability{
 				cloudformationtypes.CapabilityCapabilityIam,
 				cloudformationtypes.CapabilityCapabilityNamedIam,
@@ -95,6 +120,9 @@ func StackDeploy(ctx context.Context, stackName string, templateBody string) err
 		if err != nil {
 			return err
 		}
+		if strings.HasSuffix(status, "FAILED") || strings.HasSuffix(status, "ROLLBACK_COMPLETE") {
+			reportStackFailure(ctx, cf, ecs.NewFromConfig(cfg), stackName, started)
+		}
 		if strings.HasSuffix(status, "FAILED") {
 			log.Fatalf("[error] [stack: %s] stack deployment failed with status %s", stackName, status)
 		} else if strings.HasSuffix(status, "TERMINATED") {
@@ -114,3 +142,73 @@ func StackDeploy(ctx context.Context, stackName string, templateBody string) err
 func containsIgnoreCase(s, substr string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
 }
+
+// Get the physical ID of a resource in a stack, from its logical ID
+func stackResourceID(ctx context.Context, cf *cloudformation.Client, stackName string, logicalID string) (string, error) {
+	output, err := cf.DescribeStackResource(ctx, &cloudformation.DescribeStackResourceInput{
+		StackName:         &stackName,
+		LogicalResourceId: &logicalID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return *output.StackResourceDetail.PhysicalResourceId, nil
+}
+
+// Get the status of a stack, or an empty string when it doesn't exist
+func StackStatus(ctx context.Context, stackName string) (string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	if !stackExists(ctx, cf, stackName) {
+		return "", nil
+	}
+	return stackStatus(ctx, cf, stackName)
+}
+
+// Get the outputs of a stack by key, in a region or in the default one when the region is empty
+func StackOutputs(ctx context.Context, region string, stackName string) (map[string]string, error) {
+	options := []func(*config.LoadOptions) error{}
+	if region != "" {
+		options = append(options, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	stack, err := cf.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
+		StackName: &stackName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the stack %s in %s: %w", stackName, region, err)
+	}
+	outputs := map[string]string{}
+	for _, output := range stack.Stacks[0].Outputs {
+		outputs[*output.OutputKey] = *output.OutputValue
+	}
+	return outputs, nil
+}
+
+// Parameters of a stack, sorted by name
+func stackParameters(values map[string]string) []cloudformationtypes.Parameter {
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parameters := []cloudformationtypes.Parameter{}
+	for _, name := range names {
+		parameters = append(parameters, cloudformationtypes.Parameter{
+			ParameterKey:   ptr(name),
+			ParameterValue: ptr(values[name]),
+		})
+	}
+	return parameters
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -1,150 +1,75 @@
 package cfntemplate
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"slices"
+	"strings"
 
-	"autodock/utils"
+	"autodock/compose"
 
 	gocfn "github.com/awslabs/goformation/v7/cloudformation"
 	"github.com/awslabs/goformation/v7/cloudformation/certificatemanager"
 	"github.com/awslabs/goformation/v7/cloudformation/ec2"
 	"github.com/awslabs/goformation/v7/cloudformation/ecr"
+	"github.com/awslabs/goformation/v7/cloudformation/logs"
 	"github.com/awslabs/goformation/v7/cloudformation/route53"
 	"github.com/compose-spec/compose-go/v2/types"
 )
 
-// represent a set of strings
-type StringMapSet map[string]struct{}
-
 // Generate a "bootstrap" template, which contains common resources for the services defined in the Compose file
 // The compose file is parsed as a Compose Project
-func GenerateBootstrapTemplate(project *types.Project) string {
+func GenerateBootstrapTemplate(project *types.Project, network *Network, rootDomains []RootDomain) string {
 	template := gocfn.NewTemplate()
 
-	// A set of root domains
-	rootDomains := make(StringMapSet)
-	for _, service := range project.Services {
-		if service.Extensions["x-domain-name"] == nil {
-			log.Printf("[warn] Missing or empty x-domain-name field in service %s", service.Name)
-			continue
-		}
-		domainName := fmt.Sprint(service.Extensions["x-domain-name"])
-		rootDomain := utils.GetRootDomain(domainName)
-		if _, ok := rootDomains[rootDomain]; !ok {
-			rootDomains[rootDomain] = struct{}{}
+	// a hosted zone and a certificate per root domain, existing ones being referenced instead of created
+	for _, domain := range rootDomains {
+		name := domain.Name
+		hostedZoneID := domain.HostedZoneID
+		if hostedZoneID == "" {
+			template.Resources[HostedZoneResourceName(name)] = &route53.HostedZone{
+				Name: gocfn.String(name),
+				HostedZoneConfig: &route53.HostedZone_HostedZoneConfig{
+					Comment: gocfn.String("DNS config for " + name),
+				},
+			}
+			hostedZoneID = gocfn.Ref(HostedZoneResourceName(name))
 		}
-	}
 
-	for rootDomain := range rootDomains {
-		hostedZoneResourceName := fmt.Sprintf("%sHostedZone", utils.ToAlphabel(rootDomain))
-		template.Resources[hostedZoneResourceName] = &route53.HostedZone{
-			Name: gocfn.String(rootDomain),
-			HostedZoneConfig: &route53.HostedZone_HostedZoneConfig{
-				Comment: gocfn.String("DNS config for " + rootDomain),
-			},
+		if domain.CertificateARN != "" {
+			continue
 		}
+		for _, names := range certificateGroups(name, domainNames(project)) {
+			validationOptions := []certificatemanager.Certificate_DomainValidationOption{}
+			for _, certificateName := range names {
+				// the validation record of a wildcard is the one of its parent
+				if strings.HasPrefix(certificateName, "*.") && slices.Contains(names, strings.TrimPrefix(certificateName, "*.")) {
+					continue
+				}
+				validationOptions = append(validationOptions, certificatemanager.Certificate_DomainValidationOption{
+					DomainName:   certificateName,
+					HostedZoneId: gocfn.String(hostedZoneID),
+				})
+			}
+			template.Resources[certificateGroupResourceName(names)] = &certificatemanager.Certificate{
+				DomainName:              names[0],
+				SubjectAlternativeNames: names[1:],
+				ValidationMethod:        gocfn.String("DNS"), // Recommended for Route53 domain
 
-		certificateResourceName := fmt.Sprintf("%sCertificate", utils.ToAlphabel(rootDomain))
-		template.Resources[certificateResourceName] = &certificatemanager.Certificate{
-			DomainName: rootDomain,
-			SubjectAlternativeNames: []string{
-				fmt.Sprintf("*.%s", rootDomain),
-			},
-			ValidationMethod: gocfn.String("DNS"), // Recommended for Route53 domain
-
-			DomainValidationOptions: []certificatemanager.Certificate_DomainValidationOption{
-				{
-					DomainName:   rootDomain,
-					HostedZoneId: gocfn.String(gocfn.Ref(hostedZoneResourceName)),
-				},
-			},
+				DomainValidationOptions: validationOptions,
+			}
 		}
 	}
 
-	vpcName := fmt.Sprintf("%sVPC", project.Name)
-	template.Resources[vpcName] = &ec2.VPC{
-		CidrBlock:          gocfn.String("10.0.0.0/16"),
-		EnableDnsSupport:   gocfn.Bool(true),
-		EnableDnsHostnames: gocfn.Bool(true),
-	}
-
-	privateSubnetName1 := fmt.Sprintf("%sPrivateSubnet1", project.Name)
-	template.Resources[privateSubnetName1] = &ec2.Subnet{
-		VpcId:            gocfn.Ref(vpcName),
-		CidrBlock:        gocfn.String("10.0.1.0/24"),
-		AvailabilityZone: gocfn.String(gocfn.Select(0, gocfn.GetAZs(""))),
-	}
-
-	// 2 private subnets in different AZs to improve availability
-	privateSubnetName2 := fmt.Sprintf("%sPrivateSubnet2", project.Name)
-	template.Resources[privateSubnetName2] = &ec2.Subnet{
-		VpcId:            gocfn.Ref(vpcName),
-		CidrBlock:        gocfn.String("10.0.2.0/24"),
-		AvailabilityZone: gocfn.String(gocfn.Select(1, gocfn.GetAZs(""))),
-	}
-
-	// associate the subnets with a route table
-	privateRouteTableName := fmt.Sprintf("%sPrivateRouteTable", project.Name)
-	template.Resources[privateRouteTableName] = &ec2.RouteTable{
-		VpcId: gocfn.Ref(vpcName),
-	}
-	template.Resources["PrivateSubnet1RouteTableAssoc"] = &ec2.SubnetRouteTableAssociation{
-		SubnetId:     gocfn.Ref(privateSubnetName1),
-		RouteTableId: gocfn.Ref(privateRouteTableName),
-	}
-	template.Resources["PrivateSubnet2RouteTableAssoc"] = &ec2.SubnetRouteTableAssociation{
-		SubnetId:     gocfn.Ref(privateSubnetName2),
-		RouteTableId: gocfn.Ref(privateRouteTableName),
-	}
-
-	// internet gateway
-	template.Resources["InternetGateway"] = &ec2.InternetGateway{}
-	template.Resources["InternetGatewayAttachment"] = &ec2.VPCGatewayAttachment{
-		VpcId:             gocfn.Ref(vpcName),
-		InternetGatewayId: gocfn.String(gocfn.Ref("InternetGateway")),
-	}
-
-	// public subnets
-	publicSubnetName1 := fmt.Sprintf("%sPublicSubnet1", project.Name)
-	template.Resources[publicSubnetName1] = &ec2.Subnet{
-		VpcId:            gocfn.Ref(vpcName),
-		CidrBlock:        gocfn.String("10.0.3.0/24"),
-		AvailabilityZone: gocfn.String(gocfn.Select(0, gocfn.GetAZs(""))),
-	}
-
-	publicSubnetName2 := fmt.Sprintf("%sPublicSubnet2", project.Name)
-	template.Resources[publicSubnetName2] = &ec2.Subnet{
-		VpcId:            gocfn.Ref(vpcName),
-		CidrBlock:        gocfn.String("10.0.4.0/24"),
-		AvailabilityZone: gocfn.String(gocfn.Select(1, gocfn.GetAZs(""))),
-	}
-
-	// public route table
-	publicRouteTableName := fmt.Sprintf("%sPublicRouteTable", project.Name)
-	template.Resources[publicRouteTableName] = &ec2.RouteTable{
-		VpcId: gocfn.Ref(vpcName),
-	}
-	template.Resources["PublicRoute"] = &ec2.Route{
-		RouteTableId:         gocfn.Ref(publicRouteTableName),
-		DestinationCidrBlock: gocfn.String("0.0.0.0/0"), // Send all external traffic to the Internet Gateway
-		GatewayId:            gocfn.String(gocfn.Ref("InternetGateway")),
-	}
-	template.Resources["PublicSubnet1RouteTableAssoc"] = &ec2.SubnetRouteTableAssociation{
-		SubnetId:     gocfn.Ref(publicSubnetName1),
-		RouteTableId: gocfn.Ref(publicRouteTableName),
-	}
-	template.Resources["PublicSubnet2RouteTableAssoc"] = &ec2.SubnetRouteTableAssociation{
-		SubnetId:     gocfn.Ref(publicSubnetName2),
-		RouteTableId: gocfn.Ref(publicRouteTableName),
-	}
+	vpcID, privateSubnets, publicSubnets := addVPC(template, project, network)
 
 	// security groups
 	// for alb
 	albSecGroupName := "AlbSecurityGroup"
 	template.Resources[albSecGroupName] = &ec2.SecurityGroup{
 		GroupDescription: "For ALB",
-		VpcId:            gocfn.String(gocfn.Ref(vpcName)),
+		VpcId:            gocfn.String(vpcID),
 		SecurityGroupIngress: []ec2.SecurityGroup_Ingress{
 			{
 				IpProtocol:  "tcp",
@@ -162,11 +87,23 @@ func GenerateBootstrapTemplate(project *types.Project) string {
 			},
 		},
 	}
+	if network.ipv6 {
+		albSecGroup := template.Resources[albSecGroupName].(*ec2.SecurityGroup)
+		for _, port := range []int{443, 80} {
+			albSecGroup.SecurityGroupIngress = append(albSecGroup.SecurityGroupIngress, ec2.SecurityGroup_Ingress{
+				IpProtocol:  "tcp",
+				FromPort:    gocfn.Int(port),
+				ToPort:      gocfn.Int(port),
+				CidrIpv6:    gocfn.String("::/0"),
+				Description: gocfn.String(fmt.Sprintf("Allow port %d from anywhere over IPv6", port)),
+			})
+		}
+	}
 	// for fargate tasks
 	fargateTaskSecGroupName := "FargateTaskSecurityGroup"
 	template.Resources[fargateTaskSecGroupName] = &ec2.SecurityGroup{
 		GroupDescription: "For Fargate tasks",
-		VpcId:            gocfn.String(gocfn.Ref(vpcName)),
+		VpcId:            gocfn.String(vpcID),
 		SecurityGroupIngress: []ec2.SecurityGroup_Ingress{
 			{
 				IpProtocol:            "tcp",
@@ -181,7 +118,7 @@ func GenerateBootstrapTemplate(project *types.Project) string {
 	vpeSecGroupName := "VpcEndpointSecurityGroup"
 	template.Resources[vpeSecGroupName] = &ec2.SecurityGroup{
 		GroupDescription: "For VPC Ednpoints",
-		VpcId:            gocfn.String(gocfn.Ref(vpcName)),
+		VpcId:            gocfn.String(vpcID),
 	}
 	template.Resources["VpcEndpointSecurityGroupIngress"] = &ec2.SecurityGroupIngress{
 		GroupId:               gocfn.String(gocfn.Ref(vpeSecGroupName)),
@@ -192,79 +129,74 @@ func GenerateBootstrapTemplate(project *types.Project) string {
 		Description:           gocfn.String("Allow HTTPS to from Fargate tasks"),
 	}
 
-	// Vpc endpoints
-	// For ECR API
-	template.Resources["EcrApiVpcEndpoint"] = &ec2.VPCEndpoint{
-		VpcId:           gocfn.Ref(vpcName),
-		ServiceName:     gocfn.Sub("com.amazonaws.${AWS::Region}.ecr.api"),
-		VpcEndpointType: gocfn.String("Interface"),
-		SubnetIds: []string{
-			gocfn.Ref(privateSubnetName1),
-			gocfn.Ref(privateSubnetName2),
-		},
-		SecurityGroupIds: []string{
-			gocfn.Ref(vpeSecGroupName),
-		},
-		PrivateDnsEnabled: gocfn.Bool(true),
-	}
-	// For ECR DKR
-	template.Resources["EcrDkrVpcEndpoint"] = &ec2.VPCEndpoint{
-		VpcId:           gocfn.Ref(vpcName),
-		ServiceName:     gocfn.Sub("com.amazonaws.${AWS::Region}.ecr.dkr"),
-		VpcEndpointType: gocfn.String("Interface"),
-		SubnetIds: []string{
-			gocfn.Ref(privateSubnetName1),
-			gocfn.Ref(privateSubnetName2),
-		},
-		SecurityGroupIds: []string{
-			gocfn.Ref(vpeSecGroupName),
-		},
-		PrivateDnsEnabled: gocfn.Bool(true),
-	}
-	// For CloudWatch
-	template.Resources["CloudWatchVpcEndpoint"] = &ec2.VPCEndpoint{
-		VpcId:           gocfn.Ref(vpcName),
-		ServiceName:     gocfn.Sub("com.amazonaws.${AWS::Region}.logs"),
-		VpcEndpointType: gocfn.String("Interface"),
-		SubnetIds: []string{
-			gocfn.Ref(privateSubnetName1),
-			gocfn.Ref(privateSubnetName2),
-		},
-		SecurityGroupIds: []string{
-			gocfn.Ref(vpeSecGroupName),
-		},
-		PrivateDnsEnabled: gocfn.Bool(true),
-	}
-	// VPC Gateway Endpoint for S3 (required by ECR)
-	template.Resources["S3GatewayVpcEndpoint"] = &ec2.VPCEndpoint{
-		VpcId:           gocfn.Ref(vpcName),
-		ServiceName:     gocfn.Sub("com.amazonaws.${AWS::Region}.s3"),
-		VpcEndpointType: gocfn.String("Gateway"),
-		RouteTableIds: []string{
-			gocfn.Ref(privateRouteTableName),
-		},
-	}
+	// for named volumes, reachable from tasks of any subnet of their availability zone
+	addFileSystems(template, project, vpcID, privateSubnets, fargateTaskSecGroupName)
 
 	// create ECR repositories for each service
-	for _, service := range project.Services {
-		if service.Name == "client" {
-			template.Resources[fmt.Sprintf("ImageRepositoryFor%s", service.Name)] = &ecr.Repository{
-				RepositoryName: gocfn.String(service.Image),
+	hasJobs, hasExec := false, false
+	for _, service := range compose.DeployedServices(project) {
+		config, err := compose.ParseServiceConfig(project, &service)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		if config.IsStatic() {
+			continue
+		}
+		// one per container built from source, the images of the others are pulled
+		for _, container := range compose.TaskContainers(project, &service) {
+			if container.Service.Build == nil {
+				continue
+			}
+			template.Resources[fmt.Sprintf("ImageRepositoryFor%s", container.Service.Name)] = &ecr.Repository{
+				RepositoryName: gocfn.String(container.Service.Image),
 			}
 		}
+		hasJobs = hasJobs || config.Schedule != ""
+		hasExec = hasExec || config.Exec
 	}
 
-	template.Outputs["PrivateSubnet1"] = gocfn.Output{
-		Value: gocfn.Ref(privateSubnetName1),
-		Export: &gocfn.Export{
-			Name: fmt.Sprintf("%sPrivateSubnet1", project.Name),
-		},
+	// Tasks in the private subnets of a created VPC have no route to the internet unless it has a NAT,
+	// they reach AWS through endpoints. An existing VPC is expected to provide its own access.
+	if network.existing == nil {
+		apis, err := compose.ResourceAPIs(project)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		addVPCEndpoints(template, project, network, vpcID, privateSubnets, vpeSecGroupName, hasExec, apis)
 	}
-	template.Outputs["PrivateSubnet2"] = gocfn.Output{
-		Value: gocfn.Ref(privateSubnetName2),
-		Export: &gocfn.Export{
-			Name: fmt.Sprintf("%sPrivateSubnet2", project.Name),
-		},
+
+	// let EventBridge record the runs of scheduled jobs in their log groups.
+	// Log group resource policies are limited per region, so a single one covers every job of the project.
+	if hasJobs {
+		policy, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect": "Allow",
+					"Principal": map[string]interface{}{
+						"Service": []string{"events.amazonaws.com", "delivery.logs.amazonaws.com"},
+					},
+					"Action":   []string{"logs:CreateLogStream", "logs:PutLogEvents"},
+					"Resource": fmt.Sprintf("arn:${AWS::Partition}:logs:${AWS::Region}:${AWS::AccountId}:log-group:%s*", jobRunsLogGroupPrefix(project)),
+				},
+			},
+		})
+		if err != nil {
+			log.Fatalf("[error] Failed to generate the job runs log policy: %s", err)
+		}
+		template.Resources["JobRunsLogPolicy"] = &logs.ResourcePolicy{
+			PolicyName:     fmt.Sprintf("%s-job-runs", project.Name),
+			PolicyDocument: gocfn.Sub(string(policy)),
+		}
+	}
+
+	for i, exportName := range PrivateSubnetExports(project, network) {
+		template.Outputs[fmt.Sprintf("PrivateSubnet%d", i+1)] = gocfn.Output{
+			Value: privateSubnets[i],
+			Export: &gocfn.Export{
+				Name: exportName,
+			},
+		}
 	}
 	template.Outputs["FargateTaskSecurityGroup"] = gocfn.Output{
 		Value: gocfn.Ref(fargateTaskSecGroupName),
@@ -278,37 +210,49 @@ func GenerateBootstrapTemplate(project *types.Project) string {
 			Name: fmt.Sprintf("%sAlbSecurityGroup", project.Name),
 		},
 	}
-	template.Outputs["PublicSubnet1"] = gocfn.Output{
-		Value: gocfn.Ref(publicSubnetName1),
-		Export: &gocfn.Export{
-			Name: fmt.Sprintf("%sPublicSubnet1", project.Name),
-		},
-	}
-	template.Outputs["PublicSubnet2"] = gocfn.Output{
-		Value: gocfn.Ref(publicSubnetName2),
-		Export: &gocfn.Export{
-			Name: fmt.Sprintf("%sPublicSubnet2", project.Name),
-		},
+	for i, exportName := range PublicSubnetExports(project, network) {
+		template.Outputs[fmt.Sprintf("PublicSubnet%d", i+1)] = gocfn.Output{
+			Value: publicSubnets[i],
+			Export: &gocfn.Export{
+				Name: exportName,
+			},
+		}
 	}
 	template.Outputs["VpcId"] = gocfn.Output{
-		Value: gocfn.Ref(vpcName),
+		Value: vpcID,
 		Export: &gocfn.Export{
 			Name: fmt.Sprintf("%sVpcId", project.Name),
 		},
 	}
-	for rootDomain := range rootDomains {
-		template.Outputs[fmt.Sprintf("%sHostedZone", utils.ToAlphabel(rootDomain))] = gocfn.Output{
-			Value: gocfn.Ref(fmt.Sprintf("%sHostedZone", utils.ToAlphabel(rootDomain))),
-			Export: &gocfn.Export{
-				Name: fmt.Sprintf("%sHostedZone", utils.ToAlphabel(rootDomain)),
-			},
+	for _, domain := range rootDomains {
+		hostedZoneID := domain.HostedZoneID
+		if hostedZoneID == "" {
+			hostedZoneID = gocfn.Ref(HostedZoneResourceName(domain.Name))
 		}
-		template.Outputs[fmt.Sprintf("%sCertificate", utils.ToAlphabel(rootDomain))] = gocfn.Output{
-			Value: gocfn.Ref(fmt.Sprintf("%sCertificate", utils.ToAlphabel(rootDomain))),
+		template.Outputs[HostedZoneResourceName(domain.Name)] = gocfn.Output{
+			Value: hostedZoneID,
 			Export: &gocfn.Export{
-				Name: fmt.Sprintf("%sCertificate", utils.ToAlphabel(rootDomain)),
+				Name: HostedZoneResourceName(domain.Name),
 			},
 		}
+		if domain.CertificateARN != "" {
+			template.Outputs[CertificateResourceName(domain.Name)] = gocfn.Output{
+				Value: domain.CertificateARN,
+				Export: &gocfn.Export{
+					Name: CertificateResourceName(domain.Name),
+				},
+			}
+			continue
+		}
+		for _, names := range certificateGroups(domain.Name, domainNames(project)) {
+			certificateResourceName := certificateGroupResourceName(names)
+			template.Outputs[certificateResourceName] = gocfn.Output{
+				Value: gocfn.Ref(certificateResourceName),
+				Export: &gocfn.Export{
+					Name: certificateResourceName,
+				},
+			}
+		}
 	}
 	yml, err := template.YAML()
 	if err != nil {
@@ -0,0 +1,73 @@
+package cfntemplate
+
+import (
+	"fmt"
+
+	"autodock/compose"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/ecs"
+	"github.com/awslabs/goformation/v7/cloudformation/scheduler"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Add the ECS cluster of a service. It declares both Fargate capacity providers, so that the tasks can move between
+// them by changing the strategy of the service.
+func addCluster(template *gocfn.Template, service *types.ServiceConfig) string {
+	clusterResourceName := ClusterResourceName(service)
+	template.Resources[clusterResourceName] = &ecs.Cluster{
+		ClusterName:       gocfn.String(fmt.Sprintf("%sCluster", service.Name)),
+		CapacityProviders: []string{"FARGATE", "FARGATE_SPOT"},
+	}
+	return clusterResourceName
+}
+
+// Name of the ECS service of a service. A service with a capacity provider strategy gets another name than one with
+// the FARGATE launch type, and one deployed blue/green another name than one deployed by ECS: moving between them
+// replaces the ECS service, which CloudFormation can't do under the same name.
+func ecsServiceName(service *types.ServiceConfig, config *compose.ServiceConfig) string {
+	controller := ""
+	if config.IsBlueGreen() {
+		controller = "BlueGreen"
+	}
+	if len(config.Capacity) > 0 {
+		return fmt.Sprintf("%s%sCapacityProviderService", service.Name, controller)
+	}
+	return fmt.Sprintf("%s%sFargateService", service.Name, controller)
+}
+
+// Where the tasks of an ECS service are placed: the capacity provider strategy of the service, or the FARGATE
+// launch type without one
+func serviceCapacity(config *compose.ServiceConfig) (*string, []ecs.Service_CapacityProviderStrategyItem) {
+	if len(config.Capacity) == 0 {
+		return gocfn.String("FARGATE"), nil
+	}
+	strategy := []ecs.Service_CapacityProviderStrategyItem{}
+	for _, provider := range config.Capacity {
+		strategy = append(strategy, ecs.Service_CapacityProviderStrategyItem{
+			CapacityProvider: gocfn.String(provider.Provider),
+			Base:             optionalInt(provider.Base),
+			Weight:           gocfn.Int(provider.Weight),
+		})
+	}
+	return nil, strategy
+}
+
+// Where the tasks started by the schedule of a job are placed, as for ECS services
+func scheduleCapacity(config *compose.ServiceConfig) (*string, []scheduler.Schedule_CapacityProviderStrategyItem) {
+	if len(config.Capacity) == 0 {
+		return gocfn.String("FARGATE"), nil
+	}
+	strategy := []scheduler.Schedule_CapacityProviderStrategyItem{}
+	for _, provider := range config.Capacity {
+		item := scheduler.Schedule_CapacityProviderStrategyItem{
+			CapacityProvider: provider.Provider,
+			Weight:           gocfn.Float64(float64(provider.Weight)),
+		}
+		if provider.Base > 0 {
+			item.Base = gocfn.Float64(float64(provider.Base))
+		}
+		strategy = append(strategy, item)
+	}
+	return nil, strategy
+}
@@ -0,0 +1,227 @@
+package cfntemplate
+
+import (
+	"autodock/compose"
+	"autodock/utils"
+	"fmt"
+	"log"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/certificatemanager"
+	"github.com/awslabs/goformation/v7/cloudformation/cloudfront"
+	"github.com/awslabs/goformation/v7/cloudformation/s3"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// CloudFront only uses certificates of us-east-1, they are created in a stack of that region
+const CDNRegion = "us-east-1"
+
+// Parameter of the template of a service served through CloudFront, holding the ARN of its certificate. The outputs
+// of the us-east-1 stack can't be imported from another region, a deploy passes the ARN once the certificate exists.
+const CDNCertificateParameter = "CdnCertificateArn"
+
+// Hosted zone of the alias records pointing to a CloudFront distribution
+const cloudFrontHostedZoneID = "Z2FDTNDATAQYW2"
+
+// CloudFront managed policies: caching with the default TTLs, no caching, and forwarding every part of the request
+const (
+	cachingOptimizedPolicyID = "658327ea-f89d-4fab-a63d-7e88639e58f6"
+	cachingDisabledPolicyID  = "4135ea2d-6df8-44a3-9df3-4b5a84be39ad"
+	allViewerPolicyID        = "216adef6-5c7f-47e4-b989-5492eafa07d3"
+)
+
+// Outputs of the stack of a static site, read to upload its files
+const (
+	StaticBucketOutput = "StaticBucket"
+	DistributionOutput = "Distribution"
+)
+
+// Name of the us-east-1 stack holding the certificates of the distributions of a project
+func CDNCertificateStackName(project *types.Project) string {
+	return fmt.Sprintf("%s-cdn-certificates", project.Name)
+}
+
+// Logical ID of the certificate of the distribution of a service, also the key of its output
+func CDNCertificateResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sCdnCertificate", service.Name)
+}
+
+// Generate the template of the certificates of the services served through CloudFront, validated in the hosted
+// zones of their root domains, given by ID, to deploy in us-east-1. Empty when no service has a CDN.
+func GenerateCDNCertificateTemplate(project *types.Project, hostedZoneIDs map[string]string) string {
+	template := gocfn.NewTemplate()
+	for _, service := range compose.DeployedServices(project) {
+		config, err := compose.ParseServiceConfig(project, &service)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		if config.CDN == nil {
+			continue
+		}
+		names := config.DomainNames()
+		validationOptions := []certificatemanager.Certificate_DomainValidationOption{}
+		for _, name := range names {
+			validationOptions = append(validationOptions, certificatemanager.Certificate_DomainValidationOption{
+				DomainName:   name,
+				HostedZoneId: gocfn.String(hostedZoneIDs[utils.GetRootDomain(name)]),
+			})
+		}
+		certificateResourceName := CDNCertificateResourceName(&service)
+		template.Resources[certificateResourceName] = &certificatemanager.Certificate{
+			DomainName:              names[0],
+			SubjectAlternativeNames: names[1:],
+			ValidationMethod:        gocfn.String("DNS"),
+			DomainValidationOptions: validationOptions,
+		}
+		template.Outputs[certificateResourceName] = gocfn.Output{
+			Value: gocfn.Ref(certificateResourceName),
+		}
+	}
+	if len(template.Resources) == 0 {
+		return ""
+	}
+
+	yml, err := template.YAML()
+	if err != nil {
+		log.Fatalf("[error] Failed to generate YAML from the CDN certificates template: %s", err)
+	}
+	log.Printf("[debug] [stack %s]: Generated CDN certificates CloudFormation template:\n %s\n", project.Name, string(yml))
+	return string(yml)
+}
+
+// Create a CloudFront distribution for the names of a service in front of an origin. Requests to the cache paths
+// are cached, others are forwarded uncached with their Host header, so that the load balancer can pick the
+// certificate and the rules of the name. Static sites are cached entirely.
+func addDistribution(template *gocfn.Template, project *types.Project, service *types.ServiceConfig, config *compose.ServiceConfig, origin cloudfront.Distribution_Origin) string {
+	priceClass := config.CDN.PriceClass
+	if priceClass == "" {
+		priceClass = "PriceClass_100"
+	}
+
+	defaultBehavior := &cloudfront.Distribution_DefaultCacheBehavior{
+		TargetOriginId:        origin.Id,
+		ViewerProtocolPolicy:  "redirect-to-https",
+		AllowedMethods:        []string{"GET", "HEAD", "OPTIONS", "PUT", "PATCH", "POST", "DELETE"},
+		CachedMethods:         []string{"GET", "HEAD"},
+		CachePolicyId:         gocfn.String(cachingDisabledPolicyID),
+		OriginRequestPolicyId: gocfn.String(allViewerPolicyID),
+		Compress:              gocfn.Bool(true),
+	}
+	var defaultRootObject *string
+	if config.IsStatic() {
+		defaultBehavior.AllowedMethods = []string{"GET", "HEAD"}
+		defaultBehavior.CachePolicyId = gocfn.String(cachingOptimizedPolicyID)
+		defaultBehavior.OriginRequestPolicyId = nil
+		defaultRootObject = gocfn.String("index.html")
+	}
+	cacheBehaviors := []cloudfront.Distribution_CacheBehavior{}
+	for _, pattern := range config.CDN.CachePaths {
+		cacheBehaviors = append(cacheBehaviors, cloudfront.Distribution_CacheBehavior{
+			PathPattern:           pattern,
+			TargetOriginId:        origin.Id,
+			ViewerProtocolPolicy:  "redirect-to-https",
+			AllowedMethods:        []string{"GET", "HEAD"},
+			CachePolicyId:         gocfn.String(cachingOptimizedPolicyID),
+			OriginRequestPolicyId: gocfn.String(allViewerPolicyID),
+			Compress:              gocfn.Bool(true),
+		})
+	}
+
+	template.Parameters[CDNCertificateParameter] = gocfn.Parameter{
+		Type:        "String",
+		Description: gocfn.String("ARN of the us-east-1 certificate of the distribution, set by autodock deploy"),
+	}
+	distributionResourceName := fmt.Sprintf("%sDistribution", service.Name)
+	template.Resources[distributionResourceName] = &cloudfront.Distribution{
+		DistributionConfig: &cloudfront.Distribution_DistributionConfig{
+			Enabled:              true,
+			Comment:              gocfn.String(fmt.Sprintf("%s of %s", service.Name, project.Name)),
+			Aliases:              config.DomainNames(),
+			HttpVersion:          gocfn.String("http2and3"),
+			IPV6Enabled:          gocfn.Bool(true),
+			PriceClass:           gocfn.String(priceClass),
+			Origins:              []cloudfront.Distribution_Origin{origin},
+			DefaultRootObject:    defaultRootObject,
+			DefaultCacheBehavior: defaultBehavior,
+			CacheBehaviors:       cacheBehaviors,
+			ViewerCertificate: &cloudfront.Distribution_ViewerCertificate{
+				AcmCertificateArn:      gocfn.String(gocfn.Ref(CDNCertificateParameter)),
+				SslSupportMethod:       gocfn.String("sni-only"),
+				MinimumProtocolVersion: gocfn.String("TLSv1.2_2021"),
+			},
+		},
+	}
+	return distributionResourceName
+}
+
+// Generate the template of a static site: a private bucket holding its files, read by a CloudFront distribution
+// through an origin access control, and the records of its names. The files are uploaded by `autodock deploy`.
+func generateStaticTemplate(project *types.Project, service *types.ServiceConfig, config *compose.ServiceConfig) string {
+	template := gocfn.NewTemplate()
+
+	bucketResourceName := fmt.Sprintf("%sStaticBucket", service.Name)
+	template.Resources[bucketResourceName] = &s3.Bucket{
+		PublicAccessBlockConfiguration: &s3.Bucket_PublicAccessBlockConfiguration{
+			BlockPublicAcls:       gocfn.Bool(true),
+			BlockPublicPolicy:     gocfn.Bool(true),
+			IgnorePublicAcls:      gocfn.Bool(true),
+			RestrictPublicBuckets: gocfn.Bool(true),
+		},
+	}
+	originAccessControlResourceName := fmt.Sprintf("%sOriginAccessControl", service.Name)
+	template.Resources[originAccessControlResourceName] = &cloudfront.OriginAccessControl{
+		OriginAccessControlConfig: &cloudfront.OriginAccessControl_OriginAccessControlConfig{
+			Name:                          fmt.Sprintf("%s-%s", project.Name, service.Name),
+			OriginAccessControlOriginType: "s3",
+			SigningBehavior:               "always",
+			SigningProtocol:               "sigv4",
+		},
+	}
+
+	distributionResourceName := addDistribution(template, project, service, config, cloudfront.Distribution_Origin{
+		Id:                    "bucket",
+		DomainName:            gocfn.GetAtt(bucketResourceName, "RegionalDomainName"),
+		S3OriginConfig:        &cloudfront.Distribution_S3OriginConfig{OriginAccessIdentity: gocfn.String("")},
+		OriginAccessControlId: gocfn.String(gocfn.GetAtt(originAccessControlResourceName, "Id")),
+	})
+
+	// only the distribution can read the files
+	template.Resources[fmt.Sprintf("%sStaticBucketPolicy", service.Name)] = &s3.BucketPolicy{
+		Bucket: gocfn.Ref(bucketResourceName),
+		PolicyDocument: map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"Service": "cloudfront.amazonaws.com"},
+					"Action":    "s3:GetObject",
+					"Resource":  gocfn.Sub(fmt.Sprintf("${%s.Arn}/*", bucketResourceName)),
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]interface{}{
+							"AWS:SourceArn": gocfn.Sub(fmt.Sprintf("arn:${AWS::Partition}:cloudfront::${AWS::AccountId}:distribution/${%s}", distributionResourceName)),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for i, name := range config.DomainNames() {
+		hostedZoneID := gocfn.ImportValue(HostedZoneResourceName(utils.GetRootDomain(name)))
+		addAliasRecordSets(template, service, i, name, hostedZoneID, []string{"A", "AAAA"}, gocfn.GetAtt(distributionResourceName, "DomainName"), cloudFrontHostedZoneID)
+	}
+
+	template.Outputs[StaticBucketOutput] = gocfn.Output{
+		Value: gocfn.Ref(bucketResourceName),
+	}
+	template.Outputs[DistributionOutput] = gocfn.Output{
+		Value: gocfn.Ref(distributionResourceName),
+	}
+
+	yml, err := template.YAML()
+	if err != nil {
+		log.Fatalf("[error] Failed to generate YAML from the template of %s: %s", service.Name, err)
+	}
+	fmt.Printf("\nGenerated this CloudFormation template for stack %s:\n %s\n", project.Name, string(yml))
+	return string(yml)
+}
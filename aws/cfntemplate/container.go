@@ -0,0 +1,81 @@
+package cfntemplate
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"autodock/compose"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/ecs"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Leave a property unset when it is empty
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return gocfn.String(value)
+}
+
+// Set the settings of the Compose definition of a service on its container definition, and report the ones Fargate
+// doesn't support
+func applyContainerSettings(containerDefinition *ecs.TaskDefinition_ContainerDefinition, service *types.ServiceConfig) {
+	container, warnings := compose.FargateContainer(service)
+	if len(warnings) > 0 {
+		log.Printf("[warn] Service %s sets Compose settings that Fargate doesn't support, they are not deployed as is:\n  - %s", service.Name, strings.Join(warnings, "\n  - "))
+	}
+
+	containerDefinition.Command = container.Command
+	containerDefinition.EntryPoint = container.EntryPoint
+	containerDefinition.WorkingDirectory = optionalString(container.WorkingDirectory)
+	containerDefinition.User = optionalString(container.User)
+	containerDefinition.StopTimeout = optionalInt(container.StopTimeout)
+	containerDefinition.ReadonlyRootFilesystem = optionalBool(container.ReadOnly)
+	containerDefinition.Interactive = optionalBool(container.Interactive)
+	containerDefinition.PseudoTerminal = optionalBool(container.Tty)
+	if len(container.Labels) > 0 {
+		containerDefinition.DockerLabels = container.Labels
+	}
+	for _, ulimit := range container.Ulimits {
+		containerDefinition.Ulimits = append(containerDefinition.Ulimits, ecs.TaskDefinition_Ulimit{
+			Name:      ulimit.Name,
+			SoftLimit: ulimit.Soft,
+			HardLimit: ulimit.Hard,
+		})
+	}
+	names := []string{}
+	for name := range container.Sysctls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		containerDefinition.SystemControls = append(containerDefinition.SystemControls, ecs.TaskDefinition_SystemControl{
+			Namespace: gocfn.String(name),
+			Value:     gocfn.String(container.Sysctls[name]),
+		})
+	}
+	if container.Init || len(container.CapAdd) > 0 || len(container.CapDrop) > 0 {
+		linuxParameters := &ecs.TaskDefinition_LinuxParameters{
+			InitProcessEnabled: optionalBool(container.Init),
+		}
+		if len(container.CapAdd) > 0 || len(container.CapDrop) > 0 {
+			linuxParameters.Capabilities = &ecs.TaskDefinition_KernelCapabilities{
+				Add:  container.CapAdd,
+				Drop: container.CapDrop,
+			}
+		}
+		containerDefinition.LinuxParameters = linuxParameters
+	}
+	if healthCheck := container.HealthCheck; healthCheck != nil {
+		containerDefinition.HealthCheck = &ecs.TaskDefinition_HealthCheck{
+			Command:     healthCheck.Command,
+			Interval:    gocfn.Int(healthCheck.Interval),
+			Timeout:     gocfn.Int(healthCheck.Timeout),
+			Retries:     gocfn.Int(healthCheck.Retries),
+			StartPeriod: optionalInt(healthCheck.StartPeriod),
+		}
+	}
+}
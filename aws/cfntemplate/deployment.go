@@ -0,0 +1,167 @@
+package cfntemplate
+
+import (
+	"fmt"
+
+	"autodock/compose"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/codedeploy"
+	"github.com/awslabs/goformation/v7/cloudformation/ecs"
+	"github.com/awslabs/goformation/v7/cloudformation/iam"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Port of the listener CodeDeploy routes to the new tasks of a blue/green deployment before shifting the traffic
+const TestListenerPort = 8443
+
+// Parameters of the template of a service deployed blue/green, holding what CodeDeploy last deployed. CloudFormation
+// can't change the task definition of an ECS service deployed by CodeDeploy, nor should it switch the traffic back
+// to the target group it was created with: a deploy passes the current ones, and they are empty on the first one.
+const (
+	TaskDefinitionInUseParameter = "TaskDefinitionInUse"
+	TargetGroupInUseParameter    = "TargetGroupInUse"
+)
+
+// How an ECS service replaces its tasks with the ones of a new task definition. The circuit breaker stops a
+// deployment whose tasks keep failing and rolls the service back to the previous task definition, which fails the
+// stack update instead of leaving it waiting for tasks that never become healthy. Services deployed blue/green are
+// rolled back by CodeDeploy instead.
+func deploymentConfiguration(config *compose.ServiceConfig) *ecs.Service_DeploymentConfiguration {
+	if config.IsBlueGreen() {
+		return nil
+	}
+	deployment := &ecs.Service_DeploymentConfiguration{
+		DeploymentCircuitBreaker: &ecs.Service_DeploymentCircuitBreaker{
+			Enable:   true,
+			Rollback: true,
+		},
+		MinimumHealthyPercent: gocfn.Int(100),
+		MaximumPercent:        gocfn.Int(200),
+	}
+	if d := config.Deployment; d != nil {
+		if d.MinHealthyPercent != nil {
+			deployment.MinimumHealthyPercent = gocfn.Int(*d.MinHealthyPercent)
+		}
+		if d.MaxPercent != 0 {
+			deployment.MaximumPercent = gocfn.Int(d.MaxPercent)
+		}
+	}
+	return deployment
+}
+
+// CodeDeploy deploys the new task definitions of a service deployed blue/green, ECS the other ones
+func deploymentController(config *compose.ServiceConfig) *ecs.Service_DeploymentController {
+	if config.IsBlueGreen() {
+		return &ecs.Service_DeploymentController{Type: gocfn.String("CODE_DEPLOY")}
+	}
+	return nil
+}
+
+// Declare the parameters holding what CodeDeploy last deployed, returning the values of the ECS service and of the
+// HTTPS listener: the ones in use, or the resources of the template before the first deployment
+func addBlueGreenParameters(template *gocfn.Template, taskDefResourceName string, targetGroupResourceName string) (taskDefinition string, targetGroup string) {
+	inUse := func(parameter string, description string, resourceName string) string {
+		template.Parameters[parameter] = gocfn.Parameter{
+			Type:        "String",
+			Description: gocfn.String(description),
+			Default:     "",
+		}
+		condition := fmt.Sprintf("No%s", parameter)
+		template.Conditions[condition] = gocfn.Equals(gocfn.Ref(parameter), "")
+		return gocfn.If(condition, gocfn.Ref(resourceName), gocfn.Ref(parameter))
+	}
+	taskDefinition = inUse(TaskDefinitionInUseParameter, "ARN of the task definition the ECS service runs, set by autodock deploy", taskDefResourceName)
+	targetGroup = inUse(TargetGroupInUseParameter, "ARN of the target group receiving the traffic, set by autodock deploy", targetGroupResourceName)
+	return taskDefinition, targetGroup
+}
+
+// Add the CodeDeploy application and deployment group shifting the traffic of an ECS service between its two target
+// groups. The test listener reaches the new tasks before they receive the traffic, and a failed deployment, or one
+// of the alarms going off, shifts the traffic back to the previous tasks.
+func addBlueGreenDeployment(template *gocfn.Template, service *types.ServiceConfig, config *compose.ServiceConfig, clusterResourceName, serviceResourceName, blueTargetGroupResourceName, greenTargetGroupResourceName, listenerResourceName, testListenerResourceName string) {
+	applicationResourceName := CodeDeployApplicationResourceName(service)
+	template.Resources[applicationResourceName] = &codedeploy.Application{
+		ComputePlatform: gocfn.String("ECS"),
+	}
+
+	roleResourceName := fmt.Sprintf("%sCodeDeployRole", service.Name)
+	template.Resources[roleResourceName] = &iam.Role{
+		AssumeRolePolicyDocument: map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect": "Allow",
+					"Action": "sts:AssumeRole",
+					"Principal": map[string]interface{}{
+						"Service": "codedeploy.amazonaws.com",
+					},
+				},
+			},
+		},
+		ManagedPolicyArns: []string{
+			"arn:aws:iam::aws:policy/AWSCodeDeployRoleForECS",
+		},
+	}
+
+	trafficShifting := "CodeDeployDefault.ECSAllAtOnce"
+	if config.Deployment.TrafficShifting != "" {
+		trafficShifting = config.Deployment.TrafficShifting
+	}
+	rollbackEvents := []string{"DEPLOYMENT_FAILURE"}
+	var alarmConfiguration *codedeploy.DeploymentGroup_AlarmConfiguration
+	if len(config.Deployment.Alarms) > 0 {
+		rollbackEvents = append(rollbackEvents, "DEPLOYMENT_STOP_ON_ALARM")
+		alarmConfiguration = &codedeploy.DeploymentGroup_AlarmConfiguration{Enabled: gocfn.Bool(true)}
+		for _, alarm := range config.Deployment.Alarms {
+			alarmConfiguration.Alarms = append(alarmConfiguration.Alarms, codedeploy.DeploymentGroup_Alarm{Name: gocfn.String(alarm)})
+		}
+	}
+
+	template.Resources[DeploymentGroupResourceName(service)] = &codedeploy.DeploymentGroup{
+		ApplicationName:      gocfn.Ref(applicationResourceName),
+		ServiceRoleArn:       gocfn.GetAtt(roleResourceName, "Arn"),
+		DeploymentConfigName: gocfn.String(trafficShifting),
+		DeploymentStyle: &codedeploy.DeploymentGroup_DeploymentStyle{
+			DeploymentType:   gocfn.String("BLUE_GREEN"),
+			DeploymentOption: gocfn.String("WITH_TRAFFIC_CONTROL"),
+		},
+		BlueGreenDeploymentConfiguration: &codedeploy.DeploymentGroup_BlueGreenDeploymentConfiguration{
+			DeploymentReadyOption: &codedeploy.DeploymentGroup_DeploymentReadyOption{
+				ActionOnTimeout: gocfn.String("CONTINUE_DEPLOYMENT"),
+			},
+			// the previous tasks are kept a while, so that a rollback right after the deployment is immediate
+			TerminateBlueInstancesOnDeploymentSuccess: &codedeploy.DeploymentGroup_BlueInstanceTerminationOption{
+				Action:                       gocfn.String("TERMINATE"),
+				TerminationWaitTimeInMinutes: gocfn.Int(5),
+			},
+		},
+		AutoRollbackConfiguration: &codedeploy.DeploymentGroup_AutoRollbackConfiguration{
+			Enabled: gocfn.Bool(true),
+			Events:  rollbackEvents,
+		},
+		AlarmConfiguration: alarmConfiguration,
+		ECSServices: []codedeploy.DeploymentGroup_ECSService{
+			{
+				ClusterName: gocfn.Ref(clusterResourceName),
+				ServiceName: gocfn.GetAtt(serviceResourceName, "Name"),
+			},
+		},
+		LoadBalancerInfo: &codedeploy.DeploymentGroup_LoadBalancerInfo{
+			TargetGroupPairInfoList: []codedeploy.DeploymentGroup_TargetGroupPairInfo{
+				{
+					TargetGroups: []codedeploy.DeploymentGroup_TargetGroupInfo{
+						{Name: gocfn.String(gocfn.GetAtt(blueTargetGroupResourceName, "TargetGroupName"))},
+						{Name: gocfn.String(gocfn.GetAtt(greenTargetGroupResourceName, "TargetGroupName"))},
+					},
+					ProdTrafficRoute: &codedeploy.DeploymentGroup_TrafficRoute{
+						ListenerArns: []string{gocfn.Ref(listenerResourceName)},
+					},
+					TestTrafficRoute: &codedeploy.DeploymentGroup_TrafficRoute{
+						ListenerArns: []string{gocfn.Ref(testListenerResourceName)},
+					},
+				},
+			},
+		},
+	}
+}
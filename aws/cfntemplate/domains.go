@@ -0,0 +1,116 @@
+package cfntemplate
+
+import (
+	"autodock/compose"
+	"autodock/utils"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// The hosted zone and certificate of a root domain of the services, resolved before the templates are generated
+type RootDomain struct {
+	Name           string
+	HostedZoneID   string // of an existing zone, empty when the bootstrap stack manages the zone
+	CertificateARN string // of an existing certificate, empty when the bootstrap stack creates one
+}
+
+// Logical ID of the hosted zone of a root domain in the bootstrap stack, also the name of its export
+func HostedZoneResourceName(rootDomain string) string {
+	return fmt.Sprintf("%sHostedZone", utils.ToAlphabel(rootDomain))
+}
+
+// Logical ID of the certificate of a root domain in the bootstrap stack, also the name of its export
+func CertificateResourceName(rootDomain string) string {
+	return fmt.Sprintf("%sCertificate", utils.ToAlphabel(rootDomain))
+}
+
+// Every domain name the deployed services are served on, sorted
+func domainNames(project *types.Project) []string {
+	names := []string{}
+	for _, service := range compose.DeployedServices(project) {
+		config, err := compose.ParseServiceConfig(project, &service)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		for _, name := range config.DomainNames() {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The root domains of the services served on a domain, sorted
+func RootDomains(project *types.Project) []string {
+	rootDomains := []string{}
+	for _, name := range domainNames(project) {
+		if rootDomain := utils.GetRootDomain(name); !slices.Contains(rootDomains, rootDomain) {
+			rootDomains = append(rootDomains, rootDomain)
+		}
+	}
+	sort.Strings(rootDomains)
+	return rootDomains
+}
+
+// The name of a certificate covering a domain name: itself for a root domain, or the wildcard of its parent,
+// as a wildcard only covers a single label
+func certificateName(name string) string {
+	if name == utils.GetRootDomain(name) {
+		return name
+	}
+	_, parent, _ := strings.Cut(name, ".")
+	return "*." + parent
+}
+
+// The names in each certificate of a root domain, given the domain names of a project. The first certificate covers
+// the root domain and its direct subdomains, and each wildcard covering deeper subdomains gets a certificate of its
+// own, sorted, so that adding a name never changes the certificates the services already import.
+func certificateGroups(rootDomain string, names []string) [][]string {
+	groups := [][]string{{rootDomain, "*." + rootDomain}}
+	deeper := []string{}
+	for _, name := range names {
+		if utils.GetRootDomain(name) != rootDomain {
+			continue
+		}
+		if certificate := certificateName(name); !slices.Contains(groups[0], certificate) && !slices.Contains(deeper, certificate) {
+			deeper = append(deeper, certificate)
+		}
+	}
+	sort.Strings(deeper)
+	for _, certificate := range deeper {
+		groups = append(groups, []string{certificate})
+	}
+	return groups
+}
+
+// Logical ID of a certificate of a root domain in the bootstrap stack, also the name of its export: named after the
+// root domain for the first certificate, after the parent of its wildcard for the others
+func certificateGroupResourceName(group []string) string {
+	return CertificateResourceName(strings.TrimPrefix(group[0], "*."))
+}
+
+// Name of the bootstrap export of the certificate covering a domain name.
+// An existing certificate of the root domain is expected to cover all its names.
+func certificateExport(project *types.Project, name string) string {
+	rootDomain := utils.GetRootDomain(name)
+	config, err := compose.ParseProjectConfig(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	if config.Domain(rootDomain).CertificateARN != "" {
+		return CertificateResourceName(rootDomain)
+	}
+	for _, group := range certificateGroups(rootDomain, domainNames(project)) {
+		if slices.Contains(group, certificateName(name)) {
+			return certificateGroupResourceName(group)
+		}
+	}
+	return CertificateResourceName(rootDomain)
+}
@@ -0,0 +1,33 @@
+package cfntemplate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCertificateGroups(t *testing.T) {
+	names := []string{"example.com", "app.example.com", "api.eu.example.com", "api.us.example.com", "other.org"}
+	expected := [][]string{
+		{"example.com", "*.example.com"},
+		{"*.eu.example.com"},
+		{"*.us.example.com"},
+	}
+	groups := certificateGroups("example.com", names)
+	if !slices.EqualFunc(groups, expected, slices.Equal) {
+		t.Fatalf("certificateGroups() = %q; want %q", groups, expected)
+	}
+
+	// a name sorted before the others adds a certificate and leaves the existing ones unchanged
+	added := certificateGroups("example.com", append(names, "api.ap.example.com"))
+	for _, group := range groups {
+		i := slices.IndexFunc(added, func(other []string) bool {
+			return certificateGroupResourceName(other) == certificateGroupResourceName(group)
+		})
+		if i == -1 || !slices.Equal(added[i], group) {
+			t.Errorf("certificate %s changed after adding a name: %q", certificateGroupResourceName(group), added)
+		}
+	}
+	if len(added) != len(groups)+1 {
+		t.Errorf("certificateGroups() with an added name = %q; want %d certificates", added, len(groups)+1)
+	}
+}
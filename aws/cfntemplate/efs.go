@@ -0,0 +1,167 @@
+package cfntemplate
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"autodock/compose"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/ec2"
+	"github.com/awslabs/goformation/v7/cloudformation/ecs"
+	"github.com/awslabs/goformation/v7/cloudformation/efs"
+	"github.com/awslabs/goformation/v7/cloudformation/policies"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Logical ID of the file system of a named volume in the bootstrap stack
+func fileSystemResourceName(volume string) string {
+	return fmt.Sprintf("%sFileSystem", logicalName(volume))
+}
+
+// Name of the bootstrap export holding the file system of a named volume
+func FileSystemExport(project *types.Project, volume string) string {
+	return fmt.Sprintf("%s%sFileSystem", project.Name, logicalName(volume))
+}
+
+// Create an EFS file system per named volume of the services, with a mount target in each of the given subnets,
+// reachable over NFS from the tasks only. File systems hold data, they are kept when removed from the Compose file.
+func addFileSystems(template *gocfn.Template, project *types.Project, vpcID string, subnets []string, taskSecGroupName string) {
+	volumes := compose.PersistentVolumes(project)
+	if len(volumes) == 0 {
+		return
+	}
+
+	efsSecGroupName := "EfsSecurityGroup"
+	template.Resources[efsSecGroupName] = &ec2.SecurityGroup{
+		GroupDescription: "For EFS mount targets",
+		VpcId:            gocfn.String(vpcID),
+		SecurityGroupIngress: []ec2.SecurityGroup_Ingress{
+			{
+				IpProtocol:            "tcp",
+				FromPort:              gocfn.Int(2049),
+				ToPort:                gocfn.Int(2049),
+				SourceSecurityGroupId: gocfn.String(gocfn.Ref(taskSecGroupName)),
+				Description:           gocfn.String("Allow NFS from Fargate tasks"),
+			},
+		},
+	}
+
+	for _, volume := range volumes {
+		fileSystemResourceName := fileSystemResourceName(volume)
+		template.Resources[fileSystemResourceName] = &efs.FileSystem{
+			Encrypted:      gocfn.Bool(true),
+			ThroughputMode: gocfn.String("elastic"),
+			BackupPolicy:   &efs.FileSystem_BackupPolicy{Status: "ENABLED"},
+			LifecyclePolicies: []efs.FileSystem_LifecyclePolicy{
+				{TransitionToIA: gocfn.String("AFTER_30_DAYS")},
+			},
+			FileSystemTags: []efs.FileSystem_ElasticFileSystemTag{
+				{Key: "Name", Value: fmt.Sprintf("%s-%s", project.Name, volume)},
+			},
+			AWSCloudFormationDeletionPolicy:      policies.DeletionPolicy("Retain"),
+			AWSCloudFormationUpdateReplacePolicy: policies.UpdateReplacePolicy("Retain"),
+		}
+		for i, subnet := range subnets {
+			template.Resources[fmt.Sprintf("%sMountTarget%d", fileSystemResourceName, i+1)] = &efs.MountTarget{
+				FileSystemId:   gocfn.Ref(fileSystemResourceName),
+				SubnetId:       subnet,
+				SecurityGroups: []string{gocfn.Ref(efsSecGroupName)},
+			}
+		}
+		template.Outputs[fileSystemResourceName] = gocfn.Output{
+			Value: gocfn.Ref(fileSystemResourceName),
+			Export: &gocfn.Export{
+				Name: FileSystemExport(project, volume),
+			},
+		}
+	}
+}
+
+// The numeric uid and gid of the Compose user of a service, the files of its volumes being owned by them. Names
+// can't be resolved without the image, they are owned by root then.
+func posixUser(service *types.ServiceConfig) (uid string, gid string, ok bool) {
+	if service.User == "" {
+		return "", "", false
+	}
+	uid, gid, found := strings.Cut(service.User, ":")
+	if !found {
+		gid = uid
+	}
+	if _, err := strconv.ParseUint(uid, 10, 32); err != nil {
+		return "", "", false
+	}
+	if _, err := strconv.ParseUint(gid, 10, 32); err != nil {
+		return "", "", false
+	}
+	return uid, gid, true
+}
+
+// Add the volumes of the containers of a task: named volumes are a directory of their file system, reached through an
+// access point of the service running the task, anonymous ones live on the storage of the task. Returns the volumes
+// of the task and the mount points of each container, by service name.
+func addVolumes(template *gocfn.Template, project *types.Project, service *types.ServiceConfig, containers []compose.TaskContainer) ([]ecs.TaskDefinition_Volume, map[string][]ecs.TaskDefinition_MountPoint) {
+	volumes := []ecs.TaskDefinition_Volume{}
+	mountPoints := map[string][]ecs.TaskDefinition_MountPoint{}
+	declared := map[string]bool{}
+	for _, container := range containers {
+		member := container.Service
+		mounts, err := compose.ServiceVolumes(&member)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		for i, mount := range mounts {
+			volumeName := fmt.Sprintf("%s-anonymous-%d", member.Name, i+1)
+			if mount.Volume != "" {
+				volumeName = mount.Volume
+			}
+			mountPoints[member.Name] = append(mountPoints[member.Name], ecs.TaskDefinition_MountPoint{
+				SourceVolume:  gocfn.String(volumeName),
+				ContainerPath: gocfn.String(mount.Target),
+				ReadOnly:      gocfn.Bool(mount.ReadOnly),
+			})
+			// a volume mounted twice is declared once, with the user of the first container mounting it
+			if declared[volumeName] {
+				continue
+			}
+			declared[volumeName] = true
+			if mount.Volume == "" {
+				volumes = append(volumes, ecs.TaskDefinition_Volume{Name: gocfn.String(volumeName)})
+				continue
+			}
+
+			accessPointResourceName := fmt.Sprintf("%s%sAccessPoint", service.Name, logicalName(mount.Volume))
+			accessPoint := &efs.AccessPoint{
+				FileSystemId: gocfn.ImportValue(FileSystemExport(project, mount.Volume)),
+				RootDirectory: &efs.AccessPoint_RootDirectory{
+					Path: gocfn.String("/" + mount.Volume),
+					CreationInfo: &efs.AccessPoint_CreationInfo{
+						OwnerUid:    "0",
+						OwnerGid:    "0",
+						Permissions: "0755",
+					},
+				},
+			}
+			if uid, gid, ok := posixUser(&member); ok {
+				accessPoint.PosixUser = &efs.AccessPoint_PosixUser{Uid: uid, Gid: gid}
+				accessPoint.RootDirectory.CreationInfo.OwnerUid = uid
+				accessPoint.RootDirectory.CreationInfo.OwnerGid = gid
+			}
+			template.Resources[accessPointResourceName] = accessPoint
+			volumes = append(volumes, ecs.TaskDefinition_Volume{
+				Name: gocfn.String(volumeName),
+				EFSVolumeConfiguration: &ecs.TaskDefinition_EFSVolumeConfiguration{
+					FilesystemId:      gocfn.ImportValue(FileSystemExport(project, mount.Volume)),
+					TransitEncryption: gocfn.String("ENABLED"),
+					AuthorizationConfig: &ecs.TaskDefinition_AuthorizationConfig{
+						AccessPointId: gocfn.String(gocfn.Ref(accessPointResourceName)),
+						IAM:           gocfn.String("DISABLED"),
+					},
+				},
+			})
+		}
+	}
+	return volumes, mountPoints
+}
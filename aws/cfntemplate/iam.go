@@ -0,0 +1,174 @@
+package cfntemplate
+
+import (
+	"autodock/compose"
+	"fmt"
+	"slices"
+	"strings"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/iam"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Role assumed by the containers of a service, with the permissions declared in its x-autodock.iam block and its shorthand grants,
+// and the ones needed by the ECS Exec agent when exec is enabled
+func taskRole(service *types.ServiceConfig, config *compose.ServiceConfig) *iam.Role {
+	iamConfig := config.IAM
+	if iamConfig == nil {
+		iamConfig = &compose.IAMConfig{}
+	}
+	statements := []map[string]interface{}{}
+	if config.Exec {
+		statements = append(statements, map[string]interface{}{
+			"Effect": "Allow",
+			"Action": []string{
+				"ssmmessages:CreateControlChannel",
+				"ssmmessages:CreateDataChannel",
+				"ssmmessages:OpenControlChannel",
+				"ssmmessages:OpenDataChannel",
+			},
+			"Resource": "*",
+		})
+	}
+	for _, statement := range iamConfig.Statements {
+		effect := statement.Effect
+		if effect == "" {
+			effect = "Allow"
+		}
+		statements = append(statements, map[string]interface{}{
+			"Effect":   effect,
+			"Action":   statement.Action,
+			"Resource": statement.Resource,
+		})
+	}
+
+	statements = append(statements, grantStatements(iamConfig)...)
+
+	role := &iam.Role{
+		AssumeRolePolicyDocument: map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect": "Allow",
+					"Action": "sts:AssumeRole",
+					"Principal": map[string]interface{}{
+						"Service": "ecs-tasks.amazonaws.com",
+					},
+				},
+			},
+		},
+		ManagedPolicyArns: iamConfig.ManagedPolicies,
+	}
+	if len(statements) > 0 {
+		role.Policies = []iam.Role_Policy{
+			{
+				PolicyName: gocfn.Sub("${AWS::StackName}-" + service.Name),
+				PolicyDocument: map[string]interface{}{
+					"Version":   "2012-10-17",
+					"Statement": statements,
+				},
+			},
+		}
+	}
+	return role
+}
+
+// Actions granted on S3 objects, SQS queues and DynamoDB tables for each access level of a shorthand grant
+var grantActions = map[string]map[string][]string{
+	"s3": {
+		"read":  {"s3:GetObject"},
+		"write": {"s3:PutObject", "s3:DeleteObject", "s3:AbortMultipartUpload"},
+	},
+	"sqs": {
+		"read":  {"sqs:ReceiveMessage", "sqs:DeleteMessage", "sqs:ChangeMessageVisibility", "sqs:GetQueueAttributes", "sqs:GetQueueUrl"},
+		"write": {"sqs:SendMessage", "sqs:GetQueueAttributes", "sqs:GetQueueUrl"},
+	},
+	"dynamodb": {
+		"read":  {"dynamodb:GetItem", "dynamodb:BatchGetItem", "dynamodb:Query", "dynamodb:Scan", "dynamodb:DescribeTable", "dynamodb:ConditionCheckItem"},
+		"write": {"dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:DeleteItem", "dynamodb:BatchWriteItem", "dynamodb:DescribeTable", "dynamodb:ConditionCheckItem"},
+	},
+}
+
+// Actions of a service for an access level, read when it is not set
+func actionsFor(service string, access string) []string {
+	if access == "" {
+		access = "read"
+	}
+	actions := []string{}
+	for _, level := range strings.Split(access, "-") {
+		for _, action := range grantActions[service][level] {
+			if !slices.Contains(actions, action) {
+				actions = append(actions, action)
+			}
+		}
+	}
+	return actions
+}
+
+func grantsRead(access string) bool {
+	return access == "" || strings.Contains(access, "read")
+}
+
+// ARN of a resource given by name or ARN, a name being looked up in the partition, region and account of the stack.
+// The ARN is returned unsubstituted so that suffixes can be added before it goes through Fn::Sub.
+func arnOf(nameOrArn string, format string) string {
+	if strings.HasPrefix(nameOrArn, "arn:") {
+		return nameOrArn
+	}
+	return fmt.Sprintf(format, nameOrArn)
+}
+
+// Expand the shorthand grants of an x-autodock.iam block into least-privilege policy statements
+func grantStatements(config *compose.IAMConfig) []map[string]interface{} {
+	statements := []map[string]interface{}{}
+	for _, grant := range config.S3 {
+		bucketArn := arnOf(grant.Bucket, "arn:${AWS::Partition}:s3:::%s")
+		statements = append(statements, map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   actionsFor("s3", grant.Access),
+			"Resource": gocfn.Sub(bucketArn + "/" + grant.Prefix + "*"),
+		})
+		if grantsRead(grant.Access) {
+			list := map[string]interface{}{
+				"Effect":   "Allow",
+				"Action":   "s3:ListBucket",
+				"Resource": gocfn.Sub(bucketArn),
+			}
+			if grant.Prefix != "" {
+				list["Condition"] = map[string]interface{}{
+					"StringLike": map[string]interface{}{"s3:prefix": grant.Prefix + "*"},
+				}
+			}
+			statements = append(statements, list)
+		}
+	}
+	for _, grant := range config.SQS {
+		statements = append(statements, map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   actionsFor("sqs", grant.Access),
+			"Resource": gocfn.Sub(arnOf(grant.Queue, "arn:${AWS::Partition}:sqs:${AWS::Region}:${AWS::AccountId}:%s")),
+		})
+	}
+	for _, grant := range config.SNS {
+		statements = append(statements, map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   "sns:Publish",
+			"Resource": gocfn.Sub(arnOf(grant.Topic, "arn:${AWS::Partition}:sns:${AWS::Region}:${AWS::AccountId}:%s")),
+		})
+	}
+	for _, grant := range config.DynamoDB {
+		tableArn := arnOf(grant.Table, "arn:${AWS::Partition}:dynamodb:${AWS::Region}:${AWS::AccountId}:table/%s")
+		resources := []string{gocfn.Sub(tableArn)}
+		// queries can also target the secondary indexes of the table
+		if grantsRead(grant.Access) {
+			resources = append(resources, gocfn.Sub(tableArn+"/index/*"))
+		}
+		statements = append(statements, map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   actionsFor("dynamodb", grant.Access),
+			"Resource": resources,
+		})
+	}
+	return statements
+}
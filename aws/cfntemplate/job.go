@@ -0,0 +1,160 @@
+package cfntemplate
+
+import (
+	"autodock/compose"
+	"fmt"
+	"log"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/events"
+	"github.com/awslabs/goformation/v7/cloudformation/iam"
+	"github.com/awslabs/goformation/v7/cloudformation/logs"
+	"github.com/awslabs/goformation/v7/cloudformation/scheduler"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Log groups receiving the "task stopped" events of scheduled jobs, so that failed runs can be reported
+func jobRunsLogGroupName(project *types.Project, service *types.ServiceConfig) string {
+	return fmt.Sprintf("/aws/events/autodock/%s/%s", project.Name, service.Name)
+}
+
+func jobRunsLogGroupPrefix(project *types.Project) string {
+	return fmt.Sprintf("/aws/events/autodock/%s/", project.Name)
+}
+
+// Logical ID of the log group holding the runs of a scheduled job
+func JobRunsLogGroupResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sJobRunsLogGroup", service.Name)
+}
+
+// Generate the template of a service that runs to completion instead of as an ECS service behind a load balancer:
+// a Fargate task definition, started by EventBridge Scheduler in the task subnets of the bootstrap VPC when the
+// service has an x-autodock.schedule, or by `autodock deploy` when it is a pre-deploy hook of other services
+func generateJobTemplate(project *types.Project, service *types.ServiceConfig, config *compose.ServiceConfig, imageTags map[string]string, network *Network) string {
+	template := gocfn.NewTemplate()
+
+	clusterResourceName := addCluster(template, service)
+
+	task := addTaskDefinition(template, project, service, config, imageTags, false)
+	if config.Schedule != "" {
+		addSchedule(template, project, service, config, clusterResourceName, task, network)
+	}
+
+	yml, err := template.YAML()
+	if err != nil {
+		log.Fatalf("[error] Failed to generate YAML from a cloudformation template for job %s: %s", service.Name, err)
+	}
+	fmt.Printf("\nGenerated this CloudFormation template for job %s:\n %s\n", service.Name, string(yml))
+	return string(yml)
+}
+
+// Add the EventBridge schedule starting a job, and the recording of its runs
+func addSchedule(template *gocfn.Template, project *types.Project, service *types.ServiceConfig, config *compose.ServiceConfig, clusterResourceName string, task taskResources, network *Network) {
+	// role used by EventBridge Scheduler to start the task
+	passedRoles := []string{gocfn.GetAtt(task.executionRole, "Arn")}
+	if task.taskRole != "" {
+		passedRoles = append(passedRoles, gocfn.GetAtt(task.taskRole, "Arn"))
+	}
+	schedulerRoleResourceName := fmt.Sprintf("%sSchedulerRole", service.Name)
+	template.Resources[schedulerRoleResourceName] = &iam.Role{
+		AssumeRolePolicyDocument: map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				{
+					"Effect": "Allow",
+					"Action": "sts:AssumeRole",
+					"Principal": map[string]interface{}{
+						"Service": "scheduler.amazonaws.com",
+					},
+				},
+			},
+		},
+		Policies: []iam.Role_Policy{
+			{
+				PolicyName: "RunTask",
+				PolicyDocument: map[string]interface{}{
+					"Version": "2012-10-17",
+					"Statement": []map[string]interface{}{
+						{
+							"Effect":   "Allow",
+							"Action":   "ecs:RunTask",
+							"Resource": gocfn.Ref(task.taskDefinition),
+						},
+						{
+							"Effect":   "Allow",
+							"Action":   "iam:PassRole",
+							"Resource": passedRoles,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	timezone := config.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	launchType, capacityProviderStrategy := scheduleCapacity(config)
+	template.Resources[fmt.Sprintf("%sSchedule", service.Name)] = &scheduler.Schedule{
+		Description:                gocfn.String(fmt.Sprintf("Runs the %s job of %s", service.Name, project.Name)),
+		ScheduleExpression:         config.Schedule,
+		ScheduleExpressionTimezone: gocfn.String(timezone),
+		FlexibleTimeWindow: &scheduler.Schedule_FlexibleTimeWindow{
+			Mode: "OFF",
+		},
+		Target: &scheduler.Schedule_Target{
+			Arn:     gocfn.GetAtt(clusterResourceName, "Arn"),
+			RoleArn: gocfn.GetAtt(schedulerRoleResourceName, "Arn"),
+			EcsParameters: &scheduler.Schedule_EcsParameters{
+				TaskDefinitionArn:        gocfn.Ref(task.taskDefinition),
+				LaunchType:               launchType,
+				CapacityProviderStrategy: capacityProviderStrategy,
+				TaskCount:                gocfn.Float64(1),
+				NetworkConfiguration: &scheduler.Schedule_NetworkConfiguration{
+					AwsvpcConfiguration: &scheduler.Schedule_AwsVpcConfiguration{
+						Subnets: importValues(TaskSubnetExports(project, network)),
+						SecurityGroups: []string{
+							gocfn.ImportValue(FargateTaskSecurityGroupExport(project)),
+						},
+						AssignPublicIp: assignPublicIP(network),
+					},
+				},
+			},
+			// a failed run is reported rather than retried, jobs are not expected to be idempotent
+			RetryPolicy: &scheduler.Schedule_RetryPolicy{
+				MaximumRetryAttempts: gocfn.Float64(0),
+			},
+		},
+	}
+
+	// Keep a record of every run, with the exit code of the container, for `autodock status`.
+	// The bootstrap stack allows EventBridge to write to these log groups.
+	runsLogGroupResourceName := JobRunsLogGroupResourceName(service)
+	template.Resources[runsLogGroupResourceName] = &logs.LogGroup{
+		LogGroupName:    gocfn.String(jobRunsLogGroupName(project, service)),
+		RetentionInDays: gocfn.Int(90),
+	}
+	template.Resources[fmt.Sprintf("%sJobRunsRule", service.Name)] = &events.Rule{
+		Description: gocfn.String(fmt.Sprintf("Records the runs of the %s job", service.Name)),
+		EventPattern: map[string]interface{}{
+			"source":      []string{"aws.ecs"},
+			"detail-type": []string{"ECS Task State Change"},
+			"detail": map[string]interface{}{
+				"clusterArn": []string{gocfn.GetAtt(clusterResourceName, "Arn")},
+				"lastStatus": []string{"STOPPED"},
+				// tasks started by `autodock run` or as pre-deploy hooks aren't runs of the schedule
+				"$or": []map[string]interface{}{
+					{"startedBy": []map[string]interface{}{{"anything-but": "autodock"}}},
+					{"startedBy": []map[string]interface{}{{"exists": false}}},
+				},
+			},
+		},
+		Targets: []events.Rule_Target{
+			{
+				Id:  "JobRunsLogGroup",
+				Arn: gocfn.Sub(fmt.Sprintf("arn:${AWS::Partition}:logs:${AWS::Region}:${AWS::AccountId}:log-group:${%s}", runsLogGroupResourceName)),
+			},
+		},
+	}
+}
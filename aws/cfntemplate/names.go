@@ -0,0 +1,131 @@
+package cfntemplate
+
+import (
+	"fmt"
+	"strings"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Names shared between the generated templates and the commands that look up deployed resources
+
+// Logical ID of the ECS cluster of a service
+func ClusterResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sEcsFargateCluster", service.Name)
+}
+
+// Logical ID of the task definition of a service
+func TaskDefinitionResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sEcsTaskDefinition", service.Name)
+}
+
+// Logical ID of the ECS service of a service with a domain
+func ServiceResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sEcsFargateService", service.Name)
+}
+
+// Logical ID of the load balancer target group of a service with a domain
+func TargetGroupResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sAlbTargetGroup", service.Name)
+}
+
+// Logical ID of the HTTPS listener of a service with a domain
+func HttpsListenerResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sHttpsListener", service.Name)
+}
+
+// Logical ID of the target group receiving the new tasks of a blue/green deployment, the service starting with the
+// other one
+func GreenTargetGroupResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sAlbGreenTargetGroup", service.Name)
+}
+
+// Logical ID of the CodeDeploy application of a service deployed blue/green
+func CodeDeployApplicationResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sCodeDeployApplication", service.Name)
+}
+
+// Logical ID of the CodeDeploy deployment group of a service deployed blue/green
+func DeploymentGroupResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sDeploymentGroup", service.Name)
+}
+
+// Logical ID of the log group receiving the output of the container of a service
+func LogGroupResourceName(service *types.ServiceConfig) string {
+	return fmt.Sprintf("%sEcsTaskLogGroup", service.Name)
+}
+
+// Name of the container running a service, the Compose container_name when set
+func ContainerName(service *types.ServiceConfig) string {
+	if service.ContainerName != "" {
+		return service.ContainerName
+	}
+	return service.Name
+}
+
+// Names of the bootstrap exports holding the private subnets where tasks run
+func PrivateSubnetExports(project *types.Project, network *Network) []string {
+	exports := []string{}
+	for i := 1; i <= network.privateSubnetCount(); i++ {
+		exports = append(exports, fmt.Sprintf("%sPrivateSubnet%d", project.Name, i))
+	}
+	return exports
+}
+
+// Names of the bootstrap exports holding the public subnets where internet-facing load balancers run
+func PublicSubnetExports(project *types.Project, network *Network) []string {
+	exports := []string{}
+	for i := 1; i <= network.publicSubnetCount(); i++ {
+		exports = append(exports, fmt.Sprintf("%sPublicSubnet%d", project.Name, i))
+	}
+	return exports
+}
+
+// Name of the bootstrap export holding the security group of Fargate tasks
+func FargateTaskSecurityGroupExport(project *types.Project) string {
+	return fmt.Sprintf("%sFargateTaskSecurityGroup", project.Name)
+}
+
+// Logical ID part made of a resource name, e.g. user-uploads -> UserUploads
+func logicalName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+	for i, part := range parts {
+		parts[i] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// Names of the bootstrap exports of the subnets tasks run in: the private ones, or the public ones when tasks get a
+// public IP to reach the internet
+func TaskSubnetExports(project *types.Project, network *Network) []string {
+	if TasksHavePublicIP(network) {
+		return PublicSubnetExports(project, network)
+	}
+	return PrivateSubnetExports(project, network)
+}
+
+// Whether tasks run in the public subnets with a public IP, the public-ip egress mode
+func TasksHavePublicIP(network *Network) bool {
+	return network.tasksInPublicSubnets()
+}
+
+// AssignPublicIp setting of the tasks, in their network configuration
+func assignPublicIP(network *Network) *string {
+	if TasksHavePublicIP(network) {
+		return gocfn.String("ENABLED")
+	}
+	return gocfn.String("DISABLED")
+}
+
+// Fn::ImportValue of each export
+func importValues(exportNames []string) []string {
+	values := []string{}
+	for _, exportName := range exportNames {
+		values = append(values, gocfn.ImportValue(exportName))
+	}
+	return values
+}
@@ -0,0 +1,330 @@
+package cfntemplate
+
+import (
+	"autodock/compose"
+	"fmt"
+	"slices"
+	"strings"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/ec2"
+	"github.com/awslabs/goformation/v7/cloudformation/tags"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// An existing VPC the services run in, and its subnets
+type ExistingVPC struct {
+	ID             string
+	CIDR           string // primary range, only needed by internal services allowing the whole VPC
+	PrivateSubnets []string
+	PublicSubnets  []string
+}
+
+// The network of a project: a VPC created by the bootstrap stack, or an existing one
+type Network struct {
+	existing        *ExistingVPC // nil when the bootstrap stack creates the VPC
+	cidr            string
+	privateCIDRs    []string
+	publicCIDRs     []string
+	egress          string
+	natInstanceType string
+	ipv6            bool
+}
+
+// The network of a project: the given existing VPC, or else the VPC the bootstrap stack creates from the
+// x-autodock.vpc settings. Existing VPCs are resolved by the caller, as looking them up calls the EC2 API.
+func NewNetwork(project *types.Project, existing *ExistingVPC) (*Network, error) {
+	config, err := compose.ParseProjectConfig(project)
+	if err != nil {
+		return nil, err
+	}
+	vpc := config.VPC
+	network := &Network{existing: existing, egress: vpc.EgressMode(), natInstanceType: vpc.NATInstance(), ipv6: vpc != nil && vpc.IPv6}
+	if existing != nil {
+		return network, nil
+	}
+	network.cidr = "10.0.0.0/16"
+	if vpc != nil && vpc.CIDR != "" {
+		network.cidr = vpc.CIDR
+	}
+	network.privateCIDRs, network.publicCIDRs, err = vpc.SubnetCIDRs()
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// The primary range of the VPC
+func (n *Network) vpcCIDR() string {
+	if n.existing != nil {
+		return n.existing.CIDR
+	}
+	return n.cidr
+}
+
+func (n *Network) privateSubnetCount() int {
+	if n.existing != nil {
+		return len(n.existing.PrivateSubnets)
+	}
+	return len(n.privateCIDRs)
+}
+
+func (n *Network) publicSubnetCount() int {
+	if n.existing != nil {
+		return len(n.existing.PublicSubnets)
+	}
+	return len(n.publicCIDRs)
+}
+
+// Whether tasks run in the public subnets with a public IP, instead of in the private subnets
+func (n *Network) tasksInPublicSubnets() bool {
+	return n.egress == "public-ip"
+}
+
+// Logical IDs of the route tables of the private subnets: one per availability zone when each has its NAT gateway,
+// otherwise one shared by every private subnet. The first one keeps its name across modes so it isn't replaced.
+func privateRouteTableNames(project *types.Project, network *Network) []string {
+	names := []string{fmt.Sprintf("%sPrivateRouteTable", project.Name)}
+	if network.egress == "nat-gateway" {
+		for i := 2; i <= len(network.privateCIDRs); i++ {
+			names = append(names, fmt.Sprintf("%sPrivateRouteTable%d", project.Name, i))
+		}
+	}
+	return names
+}
+
+// Add the VPC of the project to the bootstrap template, unless an existing one is used.
+// Returns the VPC ID and the IDs of the private and public subnets, as values of the template.
+func addVPC(template *gocfn.Template, project *types.Project, network *Network) (string, []string, []string) {
+	if network.existing != nil {
+		return network.existing.ID, network.existing.PrivateSubnets, network.existing.PublicSubnets
+	}
+
+	vpcName := fmt.Sprintf("%sVPC", project.Name)
+	template.Resources[vpcName] = &ec2.VPC{
+		CidrBlock:          gocfn.String(network.cidr),
+		EnableDnsSupport:   gocfn.Bool(true),
+		EnableDnsHostnames: gocfn.Bool(true),
+	}
+
+	// a private subnet per AZ to improve availability
+	privateRouteTableNames := privateRouteTableNames(project, network)
+	for _, routeTableName := range privateRouteTableNames {
+		template.Resources[routeTableName] = &ec2.RouteTable{
+			VpcId: gocfn.Ref(vpcName),
+		}
+	}
+	privateSubnets := []string{}
+	for i, cidr := range network.privateCIDRs {
+		subnetName := fmt.Sprintf("%sPrivateSubnet%d", project.Name, i+1)
+		template.Resources[subnetName] = &ec2.Subnet{
+			VpcId:            gocfn.Ref(vpcName),
+			CidrBlock:        gocfn.String(cidr),
+			AvailabilityZone: gocfn.String(gocfn.Select(i, gocfn.GetAZs(""))),
+		}
+		template.Resources[fmt.Sprintf("PrivateSubnet%dRouteTableAssoc", i+1)] = &ec2.SubnetRouteTableAssociation{
+			SubnetId:     gocfn.Ref(subnetName),
+			RouteTableId: gocfn.Ref(privateRouteTableNames[i%len(privateRouteTableNames)]),
+		}
+		privateSubnets = append(privateSubnets, gocfn.Ref(subnetName))
+	}
+
+	// internet gateway
+	template.Resources["InternetGateway"] = &ec2.InternetGateway{}
+	template.Resources["InternetGatewayAttachment"] = &ec2.VPCGatewayAttachment{
+		VpcId:             gocfn.Ref(vpcName),
+		InternetGatewayId: gocfn.String(gocfn.Ref("InternetGateway")),
+	}
+
+	// public subnets
+	publicRouteTableName := fmt.Sprintf("%sPublicRouteTable", project.Name)
+	template.Resources[publicRouteTableName] = &ec2.RouteTable{
+		VpcId: gocfn.Ref(vpcName),
+	}
+	template.Resources["PublicRoute"] = &ec2.Route{
+		RouteTableId:         gocfn.Ref(publicRouteTableName),
+		DestinationCidrBlock: gocfn.String("0.0.0.0/0"), // Send all external traffic to the Internet Gateway
+		GatewayId:            gocfn.String(gocfn.Ref("InternetGateway")),
+	}
+	// an Amazon-provided /56 for the load balancers in the public subnets to be reached over IPv6
+	ipv6CidrBlockName := fmt.Sprintf("%sIpv6CidrBlock", vpcName)
+	if network.ipv6 {
+		template.Resources[ipv6CidrBlockName] = &ec2.VPCCidrBlock{
+			VpcId:                       gocfn.Ref(vpcName),
+			AmazonProvidedIpv6CidrBlock: gocfn.Bool(true),
+		}
+		template.Resources["PublicIpv6Route"] = &ec2.Route{
+			RouteTableId:             gocfn.Ref(publicRouteTableName),
+			DestinationIpv6CidrBlock: gocfn.String("::/0"),
+			GatewayId:                gocfn.String(gocfn.Ref("InternetGateway")),
+		}
+	}
+	publicSubnets := []string{}
+	for i, cidr := range network.publicCIDRs {
+		subnetName := fmt.Sprintf("%sPublicSubnet%d", project.Name, i+1)
+		subnet := &ec2.Subnet{
+			VpcId:            gocfn.Ref(vpcName),
+			CidrBlock:        gocfn.String(cidr),
+			AvailabilityZone: gocfn.String(gocfn.Select(i, gocfn.GetAZs(""))),
+		}
+		if network.ipv6 {
+			// a /64 per subnet, the size AWS requires
+			subnet.Ipv6CidrBlock = gocfn.String(gocfn.Select(i, gocfn.CIDR(gocfn.Select(0, gocfn.GetAtt(vpcName, "Ipv6CidrBlocks")), len(network.publicCIDRs), 64)))
+			subnet.AWSCloudFormationDependsOn = []string{ipv6CidrBlockName}
+		}
+		template.Resources[subnetName] = subnet
+		template.Resources[fmt.Sprintf("PublicSubnet%dRouteTableAssoc", i+1)] = &ec2.SubnetRouteTableAssociation{
+			SubnetId:     gocfn.Ref(subnetName),
+			RouteTableId: gocfn.Ref(publicRouteTableName),
+		}
+		publicSubnets = append(publicSubnets, gocfn.Ref(subnetName))
+	}
+
+	addEgress(template, project, network, vpcName, privateRouteTableNames, publicSubnets)
+
+	return gocfn.Ref(vpcName), privateSubnets, publicSubnets
+}
+
+// Route the internet traffic of the private subnets through NAT gateways or a NAT instance in the public subnets,
+// depending on the egress mode. Tasks of the other modes either don't reach the internet or run in the public subnets.
+func addEgress(template *gocfn.Template, project *types.Project, network *Network, vpcName string, privateRouteTableNames []string, publicSubnets []string) {
+	switch network.egress {
+	case "nat-gateway", "single-nat-gateway":
+		// each route table goes through the NAT gateway of its availability zone
+		for i, routeTableName := range privateRouteTableNames {
+			eipName := fmt.Sprintf("%sNatGateway%dEIP", project.Name, i+1)
+			template.Resources[eipName] = &ec2.EIP{
+				Domain:                     gocfn.String("vpc"),
+				AWSCloudFormationDependsOn: []string{"InternetGatewayAttachment"},
+			}
+			natGatewayName := fmt.Sprintf("%sNatGateway%d", project.Name, i+1)
+			template.Resources[natGatewayName] = &ec2.NatGateway{
+				AllocationId: gocfn.String(gocfn.GetAtt(eipName, "AllocationId")),
+				SubnetId:     publicSubnets[i],
+			}
+			template.Resources[fmt.Sprintf("PrivateRoute%d", i+1)] = &ec2.Route{
+				RouteTableId:         gocfn.Ref(routeTableName),
+				DestinationCidrBlock: gocfn.String("0.0.0.0/0"),
+				NatGatewayId:         gocfn.String(gocfn.Ref(natGatewayName)),
+			}
+		}
+	case "nat-instance":
+		securityGroupName := "NatInstanceSecurityGroup"
+		template.Resources[securityGroupName] = &ec2.SecurityGroup{
+			GroupDescription: "For the NAT instance",
+			VpcId:            gocfn.String(gocfn.Ref(vpcName)),
+			SecurityGroupIngress: []ec2.SecurityGroup_Ingress{
+				{
+					IpProtocol:  "-1",
+					CidrIp:      gocfn.String(network.cidr),
+					Description: gocfn.String("Allow traffic from the VPC"),
+				},
+			},
+		}
+		instanceName := fmt.Sprintf("%sNatInstance", project.Name)
+		template.Resources[instanceName] = &ec2.Instance{
+			InstanceType: gocfn.String(network.natInstanceType),
+			ImageId:      gocfn.String(natInstanceImage(network.natInstanceType)),
+			// the instance forwards traffic that is neither from nor to itself
+			SourceDestCheck: gocfn.Bool(false),
+			NetworkInterfaces: []ec2.Instance_NetworkInterface{
+				{
+					DeviceIndex:              "0",
+					SubnetId:                 gocfn.String(publicSubnets[0]),
+					GroupSet:                 []string{gocfn.Ref(securityGroupName)},
+					AssociatePublicIpAddress: gocfn.Bool(true),
+				},
+			},
+			UserData: gocfn.String(gocfn.Base64(natInstanceUserData)),
+			Tags: []tags.Tag{
+				{Key: "Name", Value: fmt.Sprintf("%s-nat", project.Name)},
+			},
+			AWSCloudFormationDependsOn: []string{"InternetGatewayAttachment"},
+		}
+		template.Resources["PrivateRoute1"] = &ec2.Route{
+			RouteTableId:         gocfn.Ref(privateRouteTableNames[0]),
+			DestinationCidrBlock: gocfn.String("0.0.0.0/0"),
+			InstanceId:           gocfn.String(gocfn.Ref(instanceName)),
+		}
+	}
+}
+
+// Turn an Amazon Linux instance into a NAT: forward IPv4 and masquerade what leaves through its interface
+const natInstanceUserData = `#!/bin/bash
+set -e
+dnf install -y iptables-services
+echo "net.ipv4.ip_forward = 1" > /etc/sysctl.d/90-nat.conf
+sysctl -p /etc/sysctl.d/90-nat.conf
+interface=$(ip route show default | awk '{print $5}')
+iptables -t nat -A POSTROUTING -o "$interface" -j MASQUERADE
+iptables -F FORWARD
+service iptables save
+systemctl enable --now iptables
+`
+
+// Latest Amazon Linux 2023 image for the architecture of an instance type, Graviton types having a g after the
+// generation, such as t4g or c7gn
+func natInstanceImage(instanceType string) string {
+	architecture := "x86_64"
+	family, _, _ := strings.Cut(instanceType, ".")
+	if generation := strings.IndexAny(family, "0123456789"); generation >= 0 && strings.Contains(family[generation:], "g") {
+		architecture = "arm64"
+	}
+	return fmt.Sprintf("{{resolve:ssm:/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-%s}}", architecture)
+}
+
+// Add the VPC endpoints that tasks in the private subnets of a created VPC use to reach AWS, including the APIs of
+// the resources of the project. Tasks with internet access only get the S3 and DynamoDB gateway endpoints, which are
+// free and spare NAT data processing.
+func addVPCEndpoints(template *gocfn.Template, project *types.Project, network *Network, vpcID string, privateSubnets []string, securityGroupName string, hasExec bool, apis []string) {
+	privateRouteTables := []string{}
+	for _, routeTableName := range privateRouteTableNames(project, network) {
+		privateRouteTables = append(privateRouteTables, gocfn.Ref(routeTableName))
+	}
+	// VPC Gateway Endpoint for S3 (required by ECR)
+	template.Resources["S3GatewayVpcEndpoint"] = &ec2.VPCEndpoint{
+		VpcId:           vpcID,
+		ServiceName:     gocfn.Sub("com.amazonaws.${AWS::Region}.s3"),
+		VpcEndpointType: gocfn.String("Gateway"),
+		RouteTableIds:   privateRouteTables,
+	}
+	if slices.Contains(apis, "dynamodb") {
+		template.Resources["DynamoDbGatewayVpcEndpoint"] = &ec2.VPCEndpoint{
+			VpcId:           vpcID,
+			ServiceName:     gocfn.Sub("com.amazonaws.${AWS::Region}.dynamodb"),
+			VpcEndpointType: gocfn.String("Gateway"),
+			RouteTableIds:   privateRouteTables,
+		}
+	}
+	if network.egress != "endpoints" {
+		return
+	}
+
+	interfaceEndpoints := []struct{ resourceName, service string }{
+		{"EcrApiVpcEndpoint", "ecr.api"},
+		{"EcrDkrVpcEndpoint", "ecr.dkr"},
+		{"CloudWatchVpcEndpoint", "logs"},
+	}
+	// ECS Exec sessions go through SSM Messages
+	if hasExec {
+		interfaceEndpoints = append(interfaceEndpoints, struct{ resourceName, service string }{"SsmMessagesVpcEndpoint", "ssmmessages"})
+	}
+	if slices.Contains(apis, "sqs") {
+		interfaceEndpoints = append(interfaceEndpoints, struct{ resourceName, service string }{"SqsVpcEndpoint", "sqs"})
+	}
+	if slices.Contains(apis, "sns") {
+		interfaceEndpoints = append(interfaceEndpoints, struct{ resourceName, service string }{"SnsVpcEndpoint", "sns"})
+	}
+	for _, endpoint := range interfaceEndpoints {
+		template.Resources[endpoint.resourceName] = &ec2.VPCEndpoint{
+			VpcId:           vpcID,
+			ServiceName:     gocfn.Sub("com.amazonaws.${AWS::Region}." + endpoint.service),
+			VpcEndpointType: gocfn.String("Interface"),
+			SubnetIds:       privateSubnets,
+			SecurityGroupIds: []string{
+				gocfn.Ref(securityGroupName),
+			},
+			PrivateDnsEnabled: gocfn.Bool(true),
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package cfntemplate
+
+import (
+	"autodock/compose"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/dynamodb"
+	"github.com/awslabs/goformation/v7/cloudformation/policies"
+	"github.com/awslabs/goformation/v7/cloudformation/s3"
+	"github.com/awslabs/goformation/v7/cloudformation/sns"
+	"github.com/awslabs/goformation/v7/cloudformation/sqs"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Generate the template of the project-level stack holding the S3 buckets, SQS queues, SNS topics and DynamoDB tables
+// used by the services, declared in x-autodock.resources or created by the LocalStack init hooks.
+// Returns an empty string when the project uses none.
+func GenerateResourcesTemplate(project *types.Project) string {
+	resources, err := compose.Resources(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	if len(resources) == 0 {
+		return ""
+	}
+
+	template := gocfn.NewTemplate()
+	for _, resource := range resources {
+		if len(compose.ResourceServices(project, resource)) == 0 {
+			log.Printf("[warn] No service is granted access to %s %s, list the ones using it in its services", resource.Type, resource.Name)
+		}
+		name := logicalName(resource.Name)
+		switch resource.Type {
+		case "s3":
+			// buckets and tables hold data, they are kept when removed from the Compose file
+			template.Resources[name+"Bucket"] = &s3.Bucket{
+				BucketName: gocfn.String(resource.Name),
+				PublicAccessBlockConfiguration: &s3.Bucket_PublicAccessBlockConfiguration{
+					BlockPublicAcls:       gocfn.Bool(true),
+					BlockPublicPolicy:     gocfn.Bool(true),
+					IgnorePublicAcls:      gocfn.Bool(true),
+					RestrictPublicBuckets: gocfn.Bool(true),
+				},
+				AWSCloudFormationDeletionPolicy:      policies.DeletionPolicy("Retain"),
+				AWSCloudFormationUpdateReplacePolicy: policies.UpdateReplacePolicy("Retain"),
+			}
+		case "sqs":
+			template.Resources[name+"Queue"] = &sqs.Queue{
+				QueueName: gocfn.String(resource.Name),
+				FifoQueue: optionalBool(strings.HasSuffix(resource.Name, ".fifo")),
+			}
+		case "sns":
+			template.Resources[name+"Topic"] = &sns.Topic{
+				TopicName: gocfn.String(resource.Name),
+				FifoTopic: optionalBool(strings.HasSuffix(resource.Name, ".fifo")),
+			}
+		case "dynamodb":
+			partitionKey := resource.PartitionKey
+			if partitionKey == "" {
+				partitionKey = "id:S"
+			}
+			table := &dynamodb.Table{
+				TableName:                            gocfn.String(resource.Name),
+				BillingMode:                          gocfn.String("PAY_PER_REQUEST"),
+				AWSCloudFormationDeletionPolicy:      policies.DeletionPolicy("Retain"),
+				AWSCloudFormationUpdateReplacePolicy: policies.UpdateReplacePolicy("Retain"),
+			}
+			keys := []struct{ key, keyType string }{{partitionKey, "HASH"}, {resource.SortKey, "RANGE"}}
+			for _, key := range keys {
+				if key.key == "" {
+					continue
+				}
+				attributeName, attributeType, found := strings.Cut(key.key, ":")
+				if !found {
+					attributeType = "S"
+				}
+				table.KeySchema = append(table.KeySchema, dynamodb.Table_KeySchema{AttributeName: attributeName, KeyType: key.keyType})
+				table.AttributeDefinitions = append(table.AttributeDefinitions, dynamodb.Table_AttributeDefinition{AttributeName: attributeName, AttributeType: attributeType})
+			}
+			template.Resources[name+"Table"] = table
+		}
+	}
+
+	yml, err := template.YAML()
+	if err != nil {
+		log.Fatalf("[error] Failed to generate YAML from the cloudformation template of the resources: %s", err)
+		return ""
+	}
+	fmt.Printf("\nGenerated this CloudFormation template for the resources of %s:\n %s\n", project.Name, string(yml))
+	return string(yml)
+}
+
+// Add read-write grants to the resources of the project that a service has access to, see compose.ResourceServices
+func withResourceGrants(project *types.Project, service *types.ServiceConfig, config *compose.IAMConfig) *compose.IAMConfig {
+	resources, err := compose.Resources(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	granted := &compose.IAMConfig{}
+	if config != nil {
+		*granted = *config
+		// the grants are appended to copies, not to the slices of the parsed config
+		granted.S3 = slices.Clone(config.S3)
+		granted.SQS = slices.Clone(config.SQS)
+		granted.SNS = slices.Clone(config.SNS)
+		granted.DynamoDB = slices.Clone(config.DynamoDB)
+	}
+	added := false
+	for _, resource := range resources {
+		if !slices.Contains(compose.ResourceServices(project, resource), service.Name) {
+			continue
+		}
+		added = true
+		switch resource.Type {
+		case "s3":
+			granted.S3 = append(granted.S3, compose.S3Grant{Bucket: resource.Name, Access: "read-write"})
+		case "sqs":
+			granted.SQS = append(granted.SQS, compose.QueueGrant{Queue: resource.Name, Access: "read-write"})
+		case "sns":
+			granted.SNS = append(granted.SNS, compose.TopicGrant{Topic: resource.Name})
+		case "dynamodb":
+			granted.DynamoDB = append(granted.DynamoDB, compose.TableGrant{Table: resource.Name, Access: "read-write"})
+		}
+	}
+	if config == nil && !added {
+		return nil
+	}
+	return granted
+}
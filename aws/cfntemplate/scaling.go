@@ -0,0 +1,77 @@
+package cfntemplate
+
+import (
+	"autodock/compose"
+	"fmt"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/applicationautoscaling"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Number of tasks an ECS service is created with, from the Compose `deploy.replicas` (or `scale`) of the service.
+// Once autoscaling manages the service, the count is left out of the template so that a deploy doesn't reset it.
+func desiredCount(service *types.ServiceConfig, config *compose.ServiceConfig) *int {
+	if config.Scaling != nil {
+		return nil
+	}
+	return gocfn.Int(service.GetScale())
+}
+
+// Add a scalable target for the ECS service, and a target tracking policy for each target set in x-autodock.scaling
+func addAutoscaling(template *gocfn.Template, service *types.ServiceConfig, config *compose.ServiceConfig, clusterResourceName, serviceResourceName, albResourceName, albTargetGroupResourceName string) {
+	scaling := config.Scaling
+	if scaling == nil {
+		return
+	}
+
+	minCapacity := service.GetScale()
+	if scaling.Min != nil {
+		minCapacity = *scaling.Min
+	}
+
+	scalableTargetResourceName := fmt.Sprintf("%sScalableTarget", service.Name)
+	template.Resources[scalableTargetResourceName] = &applicationautoscaling.ScalableTarget{
+		MinCapacity:       minCapacity,
+		MaxCapacity:       scaling.Max,
+		ResourceId:        gocfn.Join("/", []string{"service", gocfn.Ref(clusterResourceName), gocfn.GetAtt(serviceResourceName, "Name")}),
+		RoleARN:           gocfn.String(gocfn.Sub("arn:${AWS::Partition}:iam::${AWS::AccountId}:role/aws-service-role/ecs.application-autoscaling.amazonaws.com/AWSServiceRoleForApplicationAutoScaling_ECSService")),
+		ScalableDimension: "ecs:service:DesiredCount",
+		ServiceNamespace:  "ecs",
+	}
+
+	targetTracking := func(name string, metric *applicationautoscaling.ScalingPolicy_PredefinedMetricSpecification, target int) {
+		template.Resources[fmt.Sprintf("%s%sScalingPolicy", service.Name, name)] = &applicationautoscaling.ScalingPolicy{
+			PolicyName:      fmt.Sprintf("%s-%s", service.Name, name),
+			PolicyType:      "TargetTrackingScaling",
+			ScalingTargetId: gocfn.String(gocfn.Ref(scalableTargetResourceName)),
+			TargetTrackingScalingPolicyConfiguration: &applicationautoscaling.ScalingPolicy_TargetTrackingScalingPolicyConfiguration{
+				PredefinedMetricSpecification: metric,
+				TargetValue:                   float64(target),
+				ScaleInCooldown:               gocfn.Int(300),
+				ScaleOutCooldown:              gocfn.Int(60),
+			},
+		}
+	}
+
+	if scaling.TargetCPU != 0 {
+		targetTracking("Cpu", &applicationautoscaling.ScalingPolicy_PredefinedMetricSpecification{
+			PredefinedMetricType: "ECSServiceAverageCPUUtilization",
+		}, scaling.TargetCPU)
+	}
+	if scaling.TargetMemory != 0 {
+		targetTracking("Memory", &applicationautoscaling.ScalingPolicy_PredefinedMetricSpecification{
+			PredefinedMetricType: "ECSServiceAverageMemoryUtilization",
+		}, scaling.TargetMemory)
+	}
+	if scaling.RequestsPerTarget != 0 {
+		targetTracking("Requests", &applicationautoscaling.ScalingPolicy_PredefinedMetricSpecification{
+			PredefinedMetricType: "ALBRequestCountPerTarget",
+			// app/<alb name>/<id>/targetgroup/<target group name>/<id>
+			ResourceLabel: gocfn.String(gocfn.Join("/", []string{
+				gocfn.GetAtt(albResourceName, "LoadBalancerFullName"),
+				gocfn.GetAtt(albTargetGroupResourceName, "TargetGroupFullName"),
+			})),
+		}, scaling.RequestsPerTarget)
+	}
+}
@@ -1,16 +1,23 @@
 package cfntemplate
 
 import (
+	"autodock/compose"
 	"autodock/utils"
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"sort"
+	"strings"
 
 	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/cloudfront"
+	"github.com/awslabs/goformation/v7/cloudformation/ec2"
 	"github.com/awslabs/goformation/v7/cloudformation/ecs"
 	elbv2 "github.com/awslabs/goformation/v7/cloudformation/elasticloadbalancingv2"
 	"github.com/awslabs/goformation/v7/cloudformation/iam"
 	"github.com/awslabs/goformation/v7/cloudformation/logs"
+	"github.com/awslabs/goformation/v7/cloudformation/policies"
 	"github.com/awslabs/goformation/v7/cloudformation/route53"
 	"github.com/compose-spec/compose-go/v2/types"
 )
@@ -36,57 +43,87 @@ func choosePlatform(service *types.ServiceConfig) *ecs.TaskDefinition_RuntimePla
 	}
 }
 
-// Generate Cloudformation templates for a service defined in the Compose file
-func GenerateServiceTemplate(project *types.Project, service *types.ServiceConfig, imageTag string) string {
+// Leave a property unset when its value is zero, letting AWS pick its default
+func optionalInt(value int) *int {
+	if value == 0 {
+		return nil
+	}
+	return gocfn.Int(value)
+}
 
-	/**
-	* [ ] Add network configuration to the ECS service
-	* [ ] Deployment testing
-	* [ ] Add load balancer
-	* [ ] add dns record to load balancer
-	 */
-	template := gocfn.NewTemplate()
+// Leave a property unset when it is false
+func optionalBool(value bool) *bool {
+	if !value {
+		return nil
+	}
+	return gocfn.Bool(value)
+}
 
-	taskLogGroupName := fmt.Sprintf("ecs/%s-%s", service.Name, service.ContainerName)
-	taskLogGroupResourceName := fmt.Sprintf("%sEcsTaskLogGroup", service.Name)
+// Resource names of a service's task definition and of the roles it uses
+type taskResources struct {
+	taskDefinition string
+	executionRole  string
+	taskRole       string // empty when the containers don't need AWS permissions
+}
+
+// Add the log group, roles and Fargate task definition running a service and its sidecars.
+// imageTags holds the pushed images of the containers built from source, by service name, the others being pulled.
+// exposePort maps the container port of the service for services behind a load balancer.
+func addTaskDefinition(template *gocfn.Template, project *types.Project, service *types.ServiceConfig, config *compose.ServiceConfig, imageTags map[string]string, exposePort bool) taskResources {
+	taskLogGroupName := fmt.Sprintf("ecs/%s-%s", service.Name, ContainerName(service))
+	taskLogGroupResourceName := LogGroupResourceName(service)
 	template.Resources[taskLogGroupResourceName] = &logs.LogGroup{
 		LogGroupName: gocfn.String(taskLogGroupName),
 	}
 
-	clusterResourceName := fmt.Sprintf("%sEcsFargateCluster", service.Name)
-	template.Resources[clusterResourceName] = &ecs.Cluster{
-		ClusterName: gocfn.String(fmt.Sprintf("%sCluster", service.Name)),
-	}
-
-	envVars := []ecs.TaskDefinition_KeyValuePair{}
-	for key := range service.Environment {
-		if val, exists := os.LookupEnv(key); !exists || val == "" {
-			log.Printf("[warn] Environment variable %s doesn't exist or is empty. Using empty string as the value.", key)
+	containers := compose.TaskContainers(project, service)
+	volumes, mountPoints := addVolumes(template, project, service, containers)
+	containerDefinitions := []ecs.TaskDefinition_ContainerDefinition{}
+	for i, container := range containers {
+		member := container.Service
+		image, built := imageTags[member.Name]
+		if !built {
+			image = member.Image
 		}
-
-		envVars = append(envVars, ecs.TaskDefinition_KeyValuePair{
-			Name:  gocfn.String(key),
-			Value: gocfn.String(os.Getenv(key)),
-		})
-	}
-	containerDefinition := ecs.TaskDefinition_ContainerDefinition{
-		Name:  service.ContainerName,
-		Image: imageTag,
-		PortMappings: []ecs.TaskDefinition_PortMapping{
-			{
-				ContainerPort: gocfn.Int(3000), // TODO: get the port from the compose file
-				Protocol:      gocfn.String("tcp"),
-			},
-		},
-		LogConfiguration: &ecs.TaskDefinition_LogConfiguration{
-			LogDriver: "awslogs",
-			Options: map[string]string{
-				"awslogs-group":         gocfn.Ref(taskLogGroupResourceName),
-				"awslogs-region":        gocfn.Ref("AWS::Region"),
-				"awslogs-stream-prefix": gocfn.Ref("AWS::StackName"),
+		containerDefinition := ecs.TaskDefinition_ContainerDefinition{
+			Name:  ContainerName(&member),
+			Image: image,
+			LogConfiguration: &ecs.TaskDefinition_LogConfiguration{
+				LogDriver: "awslogs",
+				Options: map[string]string{
+					"awslogs-group":         gocfn.Ref(taskLogGroupResourceName),
+					"awslogs-region":        gocfn.Ref("AWS::Region"),
+					"awslogs-stream-prefix": gocfn.Ref("AWS::StackName"),
+				},
 			},
-		},
-		Environment: envVars,
+			Environment: containerEnvironment(project, &member),
+			MountPoints: mountPoints[member.Name],
+		}
+		applyContainerSettings(&containerDefinition, &member)
+		// the first container is the one of the service, always essential and the only one behind the load balancer
+		if i > 0 {
+			containerDefinition.Essential = gocfn.Bool(container.Essential)
+		} else if exposePort {
+			containerDefinition.PortMappings = []ecs.TaskDefinition_PortMapping{
+				{
+					ContainerPort: gocfn.Int(3000), // TODO: get the port from the compose file
+					Protocol:      gocfn.String("tcp"),
+				},
+			}
+		}
+		dependencies := []string{}
+		for name := range container.DependsOn {
+			dependencies = append(dependencies, name)
+		}
+		sort.Strings(dependencies)
+		for _, name := range dependencies {
+			dependency := project.Services[name]
+			containerDefinition.DependsOnProp = append(containerDefinition.DependsOnProp, ecs.TaskDefinition_ContainerDependency{
+				ContainerName: gocfn.String(ContainerName(&dependency)),
+				Condition:     gocfn.String(container.DependsOn[name]),
+			})
+		}
+		containerDefinitions = append(containerDefinitions, containerDefinition)
 	}
 
 	taskExecutionRoleResourceName := fmt.Sprintf("%sEcsTaskExecutionRole", service.Name)
@@ -108,79 +145,334 @@ func GenerateServiceTemplate(project *types.Project, service *types.ServiceConfi
 		},
 	}
 
-	taskDefResourceName := fmt.Sprintf("%sEcsTaskDefinition", service.Name)
+	// role assumed by the containers themselves, only needed when they call AWS APIs
+	roleConfig := *config
+	roleConfig.IAM = withResourceGrants(project, service, config.IAM)
+	var taskRoleArn *string
+	taskRoleResourceName := ""
+	if roleConfig.IAM != nil || roleConfig.Exec {
+		taskRoleResourceName = fmt.Sprintf("%sEcsTaskRole", service.Name)
+		template.Resources[taskRoleResourceName] = taskRole(service, &roleConfig)
+		taskRoleArn = gocfn.String(gocfn.GetAtt(taskRoleResourceName, "Arn"))
+	}
+
+	cpu, memory := 1024, 2048
+	if config.Size != nil {
+		cpu, memory = config.Size.CPU, config.Size.Memory
+	}
+
+	taskDefResourceName := TaskDefinitionResourceName(service)
 	template.Resources[taskDefResourceName] = &ecs.TaskDefinition{
 		NetworkMode:             gocfn.String("awsvpc"), // required for fargate
 		RequiresCompatibilities: []string{"FARGATE"},
-		ContainerDefinitions:    []ecs.TaskDefinition_ContainerDefinition{containerDefinition},
-		Cpu:                     gocfn.String("1024"),
-		Memory:                  gocfn.String("2048"),
+		ContainerDefinitions:    containerDefinitions,
+		Cpu:                     gocfn.String(fmt.Sprint(cpu)),
+		Memory:                  gocfn.String(fmt.Sprint(memory)),
 		ExecutionRoleArn:        gocfn.String(gocfn.Ref(taskExecutionRoleResourceName)),
+		TaskRoleArn:             taskRoleArn,
 		RuntimePlatform:         choosePlatform(service),
+		Volumes:                 volumes,
+	}
+
+	return taskResources{
+		taskDefinition: taskDefResourceName,
+		executionRole:  taskExecutionRoleResourceName,
+		taskRole:       taskRoleResourceName,
+	}
+}
+
+// Environment variables of the container of a service, read from the environment of autodock. The ones pointing to
+// LocalStack are left out.
+func containerEnvironment(project *types.Project, service *types.ServiceConfig) []ecs.TaskDefinition_KeyValuePair {
+	envVars := []ecs.TaskDefinition_KeyValuePair{}
+	for key := range service.Environment {
+		if compose.IsLocalStackOverride(project, service, key) {
+			log.Printf("[info] Not deploying environment variable %s of service %s, it points to LocalStack", key, service.Name)
+			continue
+		}
+		if val, exists := os.LookupEnv(key); !exists || val == "" {
+			log.Printf("[warn] Environment variable %s doesn't exist or is empty. Using empty string as the value.", key)
+		}
+
+		envVars = append(envVars, ecs.TaskDefinition_KeyValuePair{
+			Name:  gocfn.String(key),
+			Value: gocfn.String(os.Getenv(key)),
+		})
+	}
+	return envVars
+}
+
+// Security group of the load balancer of a service. Services served to anyone share the one of the bootstrap
+// stack, the others get their own, allowing only their address ranges, and access to the tasks.
+func addAlbSecurityGroup(template *gocfn.Template, project *types.Project, service *types.ServiceConfig, config *compose.ServiceConfig, network *Network) string {
+	cidrs := config.AllowCIDRs
+	if len(cidrs) == 0 {
+		switch {
+		case config.Visibility == "internal":
+			cidrs = []string{network.vpcCIDR()}
+		case config.IsBlueGreen():
+			// the shared security group doesn't open the test listener
+			cidrs = []string{"0.0.0.0/0"}
+			if network.ipv6 {
+				cidrs = append(cidrs, "::/0")
+			}
+		default:
+			return gocfn.ImportValue(fmt.Sprintf("%sAlbSecurityGroup", project.Name))
+		}
+	}
+	ports := []int{443, 80}
+	if config.IsBlueGreen() {
+		ports = append(ports, TestListenerPort)
+	}
+
+	ingress := []ec2.SecurityGroup_Ingress{}
+	for _, cidr := range cidrs {
+		for _, port := range ports {
+			rule := ec2.SecurityGroup_Ingress{
+				IpProtocol:  "tcp",
+				FromPort:    gocfn.Int(port),
+				ToPort:      gocfn.Int(port),
+				Description: gocfn.String(fmt.Sprintf("Allow port %d from %s", port, cidr)),
+			}
+			if strings.Contains(cidr, ":") {
+				rule.CidrIpv6 = gocfn.String(cidr)
+			} else {
+				rule.CidrIp = gocfn.String(cidr)
+			}
+			ingress = append(ingress, rule)
+		}
+	}
+	securityGroupResourceName := fmt.Sprintf("%sAlbSecurityGroup", service.Name)
+	template.Resources[securityGroupResourceName] = &ec2.SecurityGroup{
+		GroupDescription:     fmt.Sprintf("For the ALB of %s", service.Name),
+		VpcId:                gocfn.String(gocfn.ImportValue(fmt.Sprintf("%sVpcId", project.Name))),
+		SecurityGroupIngress: ingress,
+	}
+	template.Resources[fmt.Sprintf("%sTaskIngress", service.Name)] = &ec2.SecurityGroupIngress{
+		GroupId:               gocfn.String(gocfn.ImportValue(FargateTaskSecurityGroupExport(project))),
+		IpProtocol:            "tcp",
+		FromPort:              gocfn.Int(3000), // TODO: get the ports from compose file
+		ToPort:                gocfn.Int(3000),
+		SourceSecurityGroupId: gocfn.String(gocfn.Ref(securityGroupResourceName)),
+		Description:           gocfn.String(fmt.Sprintf("Allow traffic from the ALB of %s", service.Name)),
+	}
+	return gocfn.Ref(securityGroupResourceName)
+}
+
+// A private hosted zone for a domain name of an internal service, so that the name of its load balancer only
+// resolves inside the VPC. Each name gets its own zone, as a zone of the whole root domain would hide the public
+// records of the other names from the VPC.
+func addPrivateHostedZone(template *gocfn.Template, project *types.Project, service *types.ServiceConfig, i int, name string) string {
+	hostedZoneResourceName := fmt.Sprintf("%sPrivateHostedZone", service.Name)
+	if i > 0 {
+		hostedZoneResourceName = fmt.Sprintf("%s%d", hostedZoneResourceName, i+1)
+	}
+	template.Resources[hostedZoneResourceName] = &route53.HostedZone{
+		Name: gocfn.String(name),
+		HostedZoneConfig: &route53.HostedZone_HostedZoneConfig{
+			Comment: gocfn.String(fmt.Sprintf("Internal DNS config for %s of %s", name, service.Name)),
+		},
+		VPCs: []route53.HostedZone_VPC{
+			{
+				VPCId:     gocfn.ImportValue(fmt.Sprintf("%sVpcId", project.Name)),
+				VPCRegion: gocfn.Ref("AWS::Region"),
+			},
+		},
+	}
+	return gocfn.Ref(hostedZoneResourceName)
+}
+
+// Alias records of the i-th domain name of a service, the first name keeping the record names it had when services
+// had a single domain
+func addAliasRecordSets(template *gocfn.Template, service *types.ServiceConfig, i int, name string, hostedZoneID string, recordTypes []string, dnsName string, aliasHostedZoneID string) {
+	for _, recordType := range recordTypes {
+		recordSetResourceName := fmt.Sprintf("%sRecordSet", service.Name)
+		if recordType == "AAAA" {
+			recordSetResourceName = fmt.Sprintf("%sAaaaRecordSet", service.Name)
+		}
+		if i > 0 {
+			recordSetResourceName = fmt.Sprintf("%s%d", recordSetResourceName, i+1)
+		}
+		template.Resources[recordSetResourceName] = &route53.RecordSet{
+			Name:         name + ".",
+			HostedZoneId: gocfn.String(hostedZoneID),
+			Type:         recordType,
+			AliasTarget: &route53.RecordSet_AliasTarget{
+				DNSName:      dnsName,
+				HostedZoneId: aliasHostedZoneID,
+				// EvaluateTargetHealth: gocfn.Bool(true),
+			},
+		}
+	}
+}
+
+// Generate Cloudformation templates for a service defined in the Compose file, running the images of imageTags by
+// service name in the network of the project
+func GenerateServiceTemplate(project *types.Project, service *types.ServiceConfig, imageTags map[string]string, network *Network) string {
+
+	/**
+	* [ ] Add network configuration to the ECS service
+	* [ ] Deployment testing
+	* [ ] Add load balancer
+	* [ ] add dns record to load balancer
+	 */
+	config, err := compose.ParseServiceConfig(project, service)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	if config.IsStatic() {
+		return generateStaticTemplate(project, service, config)
+	}
+	// services without a domain are only deployed as pre-deploy hooks, see compose.DeployedServices
+	if config.Schedule != "" || config.PrimaryDomain() == "" {
+		return generateJobTemplate(project, service, config, imageTags, network)
 	}
 
+	template := gocfn.NewTemplate()
+
+	clusterResourceName := addCluster(template, service)
+
+	task := addTaskDefinition(template, project, service, config, imageTags, true)
+	taskDefResourceName := task.taskDefinition
+	serviceTaskDefinition := gocfn.Ref(taskDefResourceName)
+
 	// ALB
+	// internal load balancers live in the private subnets and can only be reached from inside the VPC
+	albScheme := "internet-facing"
+	albSubnets := importValues(PublicSubnetExports(project, network))
+	if config.Visibility == "internal" {
+		albScheme = "internal"
+		albSubnets = importValues(PrivateSubnetExports(project, network))
+	}
+	// internet-facing load balancers are also reached over IPv6 when the VPC has it
+	dualstack := albScheme == "internet-facing" && network.ipv6
 	albResourceName := fmt.Sprintf("%sAlb", service.Name)
-	template.Resources[albResourceName] = &elbv2.LoadBalancer{
-		Name:   gocfn.String(fmt.Sprintf("%sAlb", service.Name)),
-		Scheme: gocfn.String("internet-facing"),
-		Subnets: []string{
-			gocfn.ImportValue(fmt.Sprintf("%sPublicSubnet1", project.Name)),
-			gocfn.ImportValue(fmt.Sprintf("%sPublicSubnet2", project.Name)),
-		},
+	alb := &elbv2.LoadBalancer{
+		Name:    gocfn.String(fmt.Sprintf("%sAlb", service.Name)),
+		Scheme:  gocfn.String(albScheme),
+		Subnets: albSubnets,
 		SecurityGroups: []string{
-			gocfn.ImportValue(fmt.Sprintf("%sAlbSecurityGroup", project.Name)),
+			addAlbSecurityGroup(template, project, service, config, network),
 		},
 		Type: gocfn.String("application"), // Specify it's a Application Load Balancer
 	}
+	if dualstack {
+		alb.IpAddressType = gocfn.String("dualstack")
+	}
+	template.Resources[albResourceName] = alb
 
-	// Domain name record set
-	domainName := fmt.Sprint(service.Extensions["x-domain-name"])
-	if domainName == "" {
-		log.Fatalf("Empty domain name")
-	}
-	rootDomain := utils.GetRootDomain(domainName)
-	recordSetResourceName := fmt.Sprintf("%sRecordSet", service.Name)
-	template.Resources[recordSetResourceName] = &route53.RecordSet{
-		Name:         domainName + ".",
-		HostedZoneId: gocfn.String(gocfn.ImportValue(fmt.Sprintf("%sHostedZone", utils.ToAlphabel(rootDomain)))),
-		Type:         "A",
-		AliasTarget: &route53.RecordSet_AliasTarget{
-			DNSName:      gocfn.GetAtt(albResourceName, "DNSName"),
-			HostedZoneId: gocfn.GetAtt(albResourceName, "CanonicalHostedZoneID"),
-			// EvaluateTargetHealth: gocfn.Bool(true),
-		},
+	// Domain name record sets, the first one keeping the name it had when services had a single domain.
+	// Redirected names point to the load balancer too, which answers them with the redirect.
+	domainName := config.PrimaryDomain()
+	recordTypes := []string{"A"}
+	if dualstack {
+		recordTypes = append(recordTypes, "AAAA")
+	}
+	aliasDNSName, aliasHostedZoneID := gocfn.GetAtt(albResourceName, "DNSName"), gocfn.GetAtt(albResourceName, "CanonicalHostedZoneID")
+	// with a CDN the names point to the distribution, which forwards the requests to the load balancer
+	if config.CDN != nil {
+		distributionResourceName := addDistribution(template, project, service, config, cloudfront.Distribution_Origin{
+			Id:         "alb",
+			DomainName: gocfn.GetAtt(albResourceName, "DNSName"),
+			CustomOriginConfig: &cloudfront.Distribution_CustomOriginConfig{
+				OriginProtocolPolicy: "https-only",
+				OriginSSLProtocols:   []string{"TLSv1.2"},
+			},
+		})
+		aliasDNSName, aliasHostedZoneID = gocfn.GetAtt(distributionResourceName, "DomainName"), cloudFrontHostedZoneID
+		recordTypes = []string{"A", "AAAA"}
+	}
+	for i, name := range config.DomainNames() {
+		hostedZoneID := gocfn.ImportValue(HostedZoneResourceName(utils.GetRootDomain(name)))
+		if albScheme == "internal" {
+			hostedZoneID = addPrivateHostedZone(template, project, service, i, name)
+		}
+		addAliasRecordSets(template, service, i, name, hostedZoneID, recordTypes, aliasDNSName, aliasHostedZoneID)
+	}
+
+	healthCheck := compose.HealthCheckConfig{
+		Path:        "/",
+		Interval:    30,
+		Timeout:     5,
+		StatusCodes: "200",
+	}
+	if config.HealthCheck != nil {
+		healthCheck.HealthyThreshold = config.HealthCheck.HealthyThreshold
+		healthCheck.UnhealthyThreshold = config.HealthCheck.UnhealthyThreshold
+		if config.HealthCheck.Path != "" {
+			healthCheck.Path = config.HealthCheck.Path
+		}
+		if config.HealthCheck.Interval != 0 {
+			healthCheck.Interval = config.HealthCheck.Interval
+		}
+		if config.HealthCheck.Timeout != 0 {
+			healthCheck.Timeout = config.HealthCheck.Timeout
+		}
+		if config.HealthCheck.StatusCodes != "" {
+			healthCheck.StatusCodes = config.HealthCheck.StatusCodes
+		}
 	}
 
 	// ALB target group
-	albTargetGroupResourceName := fmt.Sprintf("%sAlbTargetGroup", service.Name)
-	template.Resources[albTargetGroupResourceName] = &elbv2.TargetGroup{
+	albTargetGroupResourceName := TargetGroupResourceName(service)
+	albTargetGroup := &elbv2.TargetGroup{
 		Name:       gocfn.String(fmt.Sprintf("%sAlbTargetGroup", service.Name)),
 		Protocol:   gocfn.String("HTTP"),
 		Port:       gocfn.Int(80),
 		TargetType: gocfn.String("ip"), // required for Fargate
 		VpcId:      gocfn.String(gocfn.ImportValue(fmt.Sprintf("%sVpcId", project.Name))),
 
-		HealthCheckIntervalSeconds: gocfn.Int(30),
-		HealthCheckPath:            gocfn.String("/"),
+		HealthCheckIntervalSeconds: gocfn.Int(healthCheck.Interval),
+		HealthCheckPath:            gocfn.String(healthCheck.Path),
 		HealthCheckPort:            gocfn.String("3000"),
 		HealthCheckProtocol:        gocfn.String("HTTP"),
-		HealthCheckTimeoutSeconds:  gocfn.Int(5),
+		HealthCheckTimeoutSeconds:  gocfn.Int(healthCheck.Timeout),
+		HealthyThresholdCount:      optionalInt(healthCheck.HealthyThreshold),
+		UnhealthyThresholdCount:    optionalInt(healthCheck.UnhealthyThreshold),
 		// HealthCheckEnabled:         gocfn.Bool(true),
 
-		Matcher: &elbv2.TargetGroup_Matcher{HttpCode: gocfn.String("200")},
+		Matcher: &elbv2.TargetGroup_Matcher{HttpCode: gocfn.String(healthCheck.StatusCodes)},
 	}
+	template.Resources[albTargetGroupResourceName] = albTargetGroup
 
-	httpsListenerResourceName := fmt.Sprintf("%sHttpsListener", service.Name)
+	// blue/green deployments start the new tasks in the other target group, and CodeDeploy moves the traffic to it
+	servedTargetGroup := gocfn.Ref(albTargetGroupResourceName)
+	greenTargetGroupResourceName := GreenTargetGroupResourceName(service)
+	if config.IsBlueGreen() {
+		greenTargetGroup := *albTargetGroup
+		greenTargetGroup.Name = gocfn.String(fmt.Sprintf("%sAlbGreenTargetGroup", service.Name))
+		template.Resources[greenTargetGroupResourceName] = &greenTargetGroup
+		serviceTaskDefinition, servedTargetGroup = addBlueGreenParameters(template, taskDefResourceName, albTargetGroupResourceName)
+	}
+	if config.IsBlueGreen() || (config.Deployment != nil && config.Deployment.RollbackOnSmoke) {
+		// CodeDeploy and failed smoke checks roll back to the previous task definition, which must stay registered
+		template.Resources[taskDefResourceName].(*ecs.TaskDefinition).AWSCloudFormationUpdateReplacePolicy = policies.UpdateReplacePolicy("Retain")
+	}
+
+	httpsDefaultAction := elbv2.Listener_Action{
+		Type:           "forward",
+		TargetGroupArn: gocfn.String(servedTargetGroup),
+	}
+	routedPath := strings.TrimSuffix(config.Path, "/")
+	if routedPath != "" {
+		// only requests under the path reach the service, anything else is answered by the ALB
+		httpsDefaultAction = elbv2.Listener_Action{
+			Type: "fixed-response",
+			FixedResponseConfig: &elbv2.Listener_FixedResponseConfig{
+				StatusCode:  "404",
+				ContentType: gocfn.String("text/plain"),
+				MessageBody: gocfn.String("Not Found"),
+			},
+		}
+	}
+
+	httpsListenerResourceName := HttpsListenerResourceName(service)
 	template.Resources[httpsListenerResourceName] = &elbv2.Listener{
 		LoadBalancerArn: gocfn.Ref(albResourceName),
 		Protocol:        gocfn.String("HTTPS"),
 		Port:            gocfn.Int(443),
 		DefaultActions: []elbv2.Listener_Action{
-			{
-				Type:           "forward",
-				TargetGroupArn: gocfn.String(gocfn.Ref(albTargetGroupResourceName)),
-			},
+			httpsDefaultAction,
 		},
 		AWSCloudFormationDependsOn: []string{
 			albTargetGroupResourceName,
@@ -188,12 +480,87 @@ func GenerateServiceTemplate(project *types.Project, service *types.ServiceConfi
 		},
 		Certificates: []elbv2.Listener_Certificate{
 			{
-				CertificateArn: gocfn.String(gocfn.ImportValue(fmt.Sprintf("%sCertificate", utils.ToAlphabel(rootDomain)))),
+				CertificateArn: gocfn.String(gocfn.ImportValue(certificateExport(project, domainName))),
 			},
 		},
 		SslPolicy: gocfn.String("ELBSecurityPolicy-2016-08"),
 	}
 
+	// the certificates of the other domain names are picked by SNI
+	certificateExports := []string{certificateExport(project, domainName)}
+	listenerCertificates := []elbv2.ListenerCertificate_Certificate{}
+	for _, name := range config.DomainNames() {
+		if export := certificateExport(project, name); !slices.Contains(certificateExports, export) {
+			certificateExports = append(certificateExports, export)
+			listenerCertificates = append(listenerCertificates, elbv2.ListenerCertificate_Certificate{
+				CertificateArn: gocfn.String(gocfn.ImportValue(export)),
+			})
+		}
+	}
+	if len(listenerCertificates) > 0 {
+		template.Resources[fmt.Sprintf("%sListenerCertificates", service.Name)] = &elbv2.ListenerCertificate{
+			ListenerArn:  gocfn.Ref(httpsListenerResourceName),
+			Certificates: listenerCertificates,
+		}
+	}
+
+	// redirected names are answered with a 301 to their target, before any other rule
+	redirects := config.Redirects()
+	for i, redirect := range redirects {
+		template.Resources[fmt.Sprintf("%sRedirect%dListenerRule", service.Name, i+1)] = &elbv2.ListenerRule{
+			ListenerArn: gocfn.String(gocfn.Ref(httpsListenerResourceName)),
+			Priority:    10 + i,
+			Conditions: []elbv2.ListenerRule_RuleCondition{
+				{
+					Field: gocfn.String("host-header"),
+					HostHeaderConfig: &elbv2.ListenerRule_HostHeaderConfig{
+						Values: []string{redirect.Name},
+					},
+				},
+			},
+			Actions: []elbv2.ListenerRule_Action{
+				{
+					Type: "redirect",
+					RedirectConfig: &elbv2.ListenerRule_RedirectConfig{
+						Host:       gocfn.String(redirect.Redirect),
+						Protocol:   gocfn.String("HTTPS"),
+						Port:       gocfn.String("443"),
+						Path:       gocfn.String("/#{path}"),
+						Query:      gocfn.String("#{query}"),
+						StatusCode: "HTTP_301",
+					},
+				},
+			},
+		}
+	}
+
+	if routedPath != "" {
+		// The path rule comes after the redirects. It is renamed rather than moved when redirects are added, as a new
+		// rule can't take the priority of the existing one before it is deleted.
+		pathRuleResourceName, pathRulePriority := fmt.Sprintf("%sPathListenerRule", service.Name), 1
+		if len(redirects) > 0 {
+			pathRuleResourceName, pathRulePriority = fmt.Sprintf("%sServedPathListenerRule", service.Name), 100
+		}
+		template.Resources[pathRuleResourceName] = &elbv2.ListenerRule{
+			ListenerArn: gocfn.String(gocfn.Ref(httpsListenerResourceName)),
+			Priority:    pathRulePriority,
+			Conditions: []elbv2.ListenerRule_RuleCondition{
+				{
+					Field: gocfn.String("path-pattern"),
+					PathPatternConfig: &elbv2.ListenerRule_PathPatternConfig{
+						Values: []string{routedPath, routedPath + "/*"},
+					},
+				},
+			},
+			Actions: []elbv2.ListenerRule_Action{
+				{
+					Type:           "forward",
+					TargetGroupArn: gocfn.String(gocfn.Ref(albTargetGroupResourceName)),
+				},
+			},
+		}
+	}
+
 	// redirect to httpsListener
 	httpListenerResourceName := fmt.Sprintf("%sHttpListener", service.Name)
 	template.Resources[httpListenerResourceName] = &elbv2.Listener{
@@ -215,39 +582,73 @@ func GenerateServiceTemplate(project *types.Project, service *types.ServiceConfi
 		},
 	}
 
+	serviceDependsOn := []string{
+		albTargetGroupResourceName,
+		albResourceName,
+		httpsListenerResourceName,
+		httpListenerResourceName,
+	}
+	testListenerResourceName := fmt.Sprintf("%sTestListener", service.Name)
+	if config.IsBlueGreen() {
+		template.Resources[testListenerResourceName] = &elbv2.Listener{
+			LoadBalancerArn: gocfn.Ref(albResourceName),
+			Protocol:        gocfn.String("HTTPS"),
+			Port:            gocfn.Int(TestListenerPort),
+			DefaultActions: []elbv2.Listener_Action{
+				{
+					Type:           "forward",
+					TargetGroupArn: gocfn.String(gocfn.Ref(greenTargetGroupResourceName)),
+				},
+			},
+			Certificates: []elbv2.Listener_Certificate{
+				{
+					CertificateArn: gocfn.String(gocfn.ImportValue(certificateExport(project, domainName))),
+				},
+			},
+			SslPolicy: gocfn.String("ELBSecurityPolicy-2016-08"),
+		}
+		serviceDependsOn = append(serviceDependsOn, greenTargetGroupResourceName, testListenerResourceName)
+	}
+
 	// ECS service
-	serviceResourceName := fmt.Sprintf("%sEcsFargateService", service.Name)
+	serviceResourceName := ServiceResourceName(service)
+	launchType, capacityProviderStrategy := serviceCapacity(config)
 	template.Resources[serviceResourceName] = &ecs.Service{
-		ServiceName:    gocfn.String(fmt.Sprintf("%sFargateService", service.Name)),
-		Cluster:        gocfn.String(gocfn.Ref(clusterResourceName)),
-		DesiredCount:   gocfn.Int(1),
-		LaunchType:     gocfn.String("FARGATE"),
-		TaskDefinition: gocfn.String(gocfn.Ref(taskDefResourceName)),
+		ServiceName:  gocfn.String(ecsServiceName(service, config)),
+		Cluster:      gocfn.String(gocfn.Ref(clusterResourceName)),
+		DesiredCount: desiredCount(service, config),
+		// lets `autodock exec` open a shell in the running containers
+		EnableExecuteCommand:     optionalBool(config.Exec),
+		LaunchType:               launchType,
+		CapacityProviderStrategy: capacityProviderStrategy,
+		TaskDefinition:           gocfn.String(serviceTaskDefinition),
+		DeploymentConfiguration:  deploymentConfiguration(config),
+		DeploymentController:     deploymentController(config),
 		NetworkConfiguration: &ecs.Service_NetworkConfiguration{
 			AwsvpcConfiguration: &ecs.Service_AwsVpcConfiguration{
-				Subnets: []string{
-					gocfn.ImportValue(fmt.Sprintf("%sPrivateSubnet1", project.Name)),
-					gocfn.ImportValue(fmt.Sprintf("%sPrivateSubnet2", project.Name)),
-				},
+				Subnets: importValues(TaskSubnetExports(project, network)),
 				SecurityGroups: []string{
-					gocfn.ImportValue(fmt.Sprintf("%sFargateTaskSecurityGroup", project.Name)),
+					gocfn.ImportValue(FargateTaskSecurityGroupExport(project)),
 				},
-				AssignPublicIp: gocfn.String("DISABLED"),
+				AssignPublicIp: assignPublicIP(network),
 			},
 		},
 		LoadBalancers: []ecs.Service_LoadBalancer{
 			{
-				ContainerName:  gocfn.String(service.ContainerName),
+				ContainerName:  gocfn.String(ContainerName(service)),
 				ContainerPort:  gocfn.Int(3000), // TODO: get the port from the compose file
 				TargetGroupArn: gocfn.String(gocfn.Ref(albTargetGroupResourceName)),
 			},
 		},
-		AWSCloudFormationDependsOn: []string{
-			albTargetGroupResourceName,
-			albResourceName,
-			httpsListenerResourceName,
-			httpListenerResourceName,
-		},
+		AWSCloudFormationDependsOn: serviceDependsOn,
+	}
+
+	if config.IsBlueGreen() {
+		addBlueGreenDeployment(template, service, config, clusterResourceName, serviceResourceName, albTargetGroupResourceName, greenTargetGroupResourceName, httpsListenerResourceName, testListenerResourceName)
+	}
+	addAutoscaling(template, service, config, clusterResourceName, serviceResourceName, albResourceName, albTargetGroupResourceName)
+	if config.WAF != nil {
+		addWAF(template, project, service, config, albResourceName)
 	}
 
 	yml, err := template.YAML()
@@ -0,0 +1,100 @@
+package cfntemplate
+
+import (
+	"autodock/compose"
+	"fmt"
+
+	gocfn "github.com/awslabs/goformation/v7/cloudformation"
+	"github.com/awslabs/goformation/v7/cloudformation/logs"
+	"github.com/awslabs/goformation/v7/cloudformation/wafv2"
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Log group of the requests filtered by the web ACL of a service, WAF only logs to groups named aws-waf-logs-*
+func wafLogGroupName(project *types.Project, service *types.ServiceConfig) string {
+	return fmt.Sprintf("aws-waf-logs-%s-%s", project.Name, service.Name)
+}
+
+// CloudWatch metric and sampled requests settings of the web ACL or one of its rules
+func wafVisibility(metricName string) *wafv2.WebACL_VisibilityConfig {
+	return &wafv2.WebACL_VisibilityConfig{
+		CloudWatchMetricsEnabled: true,
+		MetricName:               metricName,
+		SampledRequestsEnabled:   true,
+	}
+}
+
+// Create a web ACL with the rate limit and managed rule groups of the service, associated with its load balancer.
+// In count mode the rules only count the requests they match, so that they can be checked in the logs before
+// they block anything.
+func addWAF(template *gocfn.Template, project *types.Project, service *types.ServiceConfig, config *compose.ServiceConfig, albResourceName string) {
+	waf := config.WAF
+	count := waf.Mode == "count"
+	metricPrefix := logicalName(service.Name)
+
+	rules := []wafv2.WebACL_Rule{}
+	if waf.RateLimit > 0 {
+		action := &wafv2.WebACL_RuleAction{Block: &wafv2.WebACL_BlockAction{}}
+		if count {
+			action = &wafv2.WebACL_RuleAction{Count: &wafv2.WebACL_CountAction{}}
+		}
+		rules = append(rules, wafv2.WebACL_Rule{
+			Name:     "RateLimit",
+			Priority: 0,
+			Action:   action,
+			Statement: &wafv2.WebACL_Statement{
+				RateBasedStatement: &wafv2.WebACL_RateBasedStatement{
+					AggregateKeyType: "IP",
+					Limit:            waf.RateLimit,
+				},
+			},
+			VisibilityConfig: wafVisibility(metricPrefix + "RateLimit"),
+		})
+	}
+	for i, name := range waf.Rules() {
+		// the actions of the rules of a group are kept, or all turned into counts
+		override := &wafv2.WebACL_OverrideAction{None: map[string]interface{}{}}
+		if count {
+			override = &wafv2.WebACL_OverrideAction{Count: map[string]interface{}{}}
+		}
+		rules = append(rules, wafv2.WebACL_Rule{
+			Name:           name,
+			Priority:       i + 1,
+			OverrideAction: override,
+			Statement: &wafv2.WebACL_Statement{
+				ManagedRuleGroupStatement: &wafv2.WebACL_ManagedRuleGroupStatement{
+					VendorName: "AWS",
+					Name:       name,
+				},
+			},
+			VisibilityConfig: wafVisibility(metricPrefix + name),
+		})
+	}
+
+	webACLResourceName := fmt.Sprintf("%sWebAcl", service.Name)
+	template.Resources[webACLResourceName] = &wafv2.WebACL{
+		Name:             gocfn.String(fmt.Sprintf("%s-%s", project.Name, service.Name)),
+		Description:      gocfn.String(fmt.Sprintf("Filters the requests to %s", service.Name)),
+		Scope:            "REGIONAL",
+		DefaultAction:    &wafv2.WebACL_DefaultAction{Allow: &wafv2.WebACL_AllowAction{}},
+		Rules:            rules,
+		VisibilityConfig: wafVisibility(metricPrefix + "WebAcl"),
+	}
+	template.Resources[fmt.Sprintf("%sWebAclAssociation", service.Name)] = &wafv2.WebACLAssociation{
+		ResourceArn: gocfn.Ref(albResourceName),
+		WebACLArn:   gocfn.GetAtt(webACLResourceName, "Arn"),
+	}
+
+	logGroupResourceName := fmt.Sprintf("%sWafLogGroup", service.Name)
+	template.Resources[logGroupResourceName] = &logs.LogGroup{
+		LogGroupName:    gocfn.String(wafLogGroupName(project, service)),
+		RetentionInDays: gocfn.Int(90),
+	}
+	template.Resources[fmt.Sprintf("%sWafLogging", service.Name)] = &wafv2.LoggingConfiguration{
+		ResourceArn: gocfn.GetAtt(webACLResourceName, "Arn"),
+		// the ARN of the log group without the :* suffix of its Arn attribute
+		LogDestinationConfigs: []string{
+			gocfn.Sub(fmt.Sprintf("arn:${AWS::Partition}:logs:${AWS::Region}:${AWS::AccountId}:log-group:${%s}", logGroupResourceName)),
+		},
+	}
+}
@@ -0,0 +1,247 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/codedeploy"
+	codedeploytypes "github.com/aws/aws-sdk-go-v2/service/codedeploy/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+// The resources of a service deployed blue/green by CodeDeploy, given by the logical IDs of its stack resources
+type BlueGreenResources struct {
+	StackName                   string
+	ClusterResourceName         string
+	ServiceResourceName         string
+	TaskDefinitionResourceName  string
+	ListenerResourceName        string
+	ApplicationResourceName     string
+	DeploymentGroupResourceName string
+	ContainerName               string
+	ContainerPort               int
+}
+
+// What CodeDeploy last deployed: the task definition run by the ECS service and the target group the listener
+// forwards the traffic to, given by ARN
+type BlueGreenState struct {
+	TaskDefinition string
+	TargetGroup    string
+}
+
+// Get what the ECS service of a deployed stack runs and where its listener forwards the traffic. Both are empty
+// before the stack is created, and the target group is empty when the listener doesn't forward the requests.
+func DescribeBlueGreenState(ctx context.Context, resources BlueGreenResources) (BlueGreenState, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+
+	state := BlueGreenState{}
+	if !stackExists(ctx, cf, resources.StackName) {
+		return state, nil
+	}
+	state.TaskDefinition, err = serviceTaskDefinition(ctx, cf, ecs.NewFromConfig(cfg), resources.StackName, resources.ClusterResourceName, resources.ServiceResourceName)
+	if err != nil {
+		return state, err
+	}
+
+	listenerArn, err := stackResourceID(ctx, cf, resources.StackName, resources.ListenerResourceName)
+	if err != nil {
+		return state, fmt.Errorf("failed to find the listener of stack %s: %w", resources.StackName, err)
+	}
+	listeners, err := elasticloadbalancingv2.NewFromConfig(cfg).DescribeListeners(ctx, &elasticloadbalancingv2.DescribeListenersInput{ListenerArns: []string{listenerArn}})
+	if err != nil {
+		return state, err
+	}
+	for _, listener := range listeners.Listeners {
+		for _, action := range listener.DefaultActions {
+			if action.TargetGroupArn != nil {
+				state.TargetGroup = *action.TargetGroupArn
+			}
+		}
+	}
+	return state, nil
+}
+
+// The task definition run by the ECS service of a stack: the one of its primary task set when CodeDeploy deployed
+// it, as the service keeps the one it was created with
+func serviceTaskDefinition(ctx context.Context, cf *cloudformation.Client, ecsClient *ecs.Client, stackName, clusterResourceName, serviceResourceName string) (string, error) {
+	cluster, err := stackResourceID(ctx, cf, stackName, clusterResourceName)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the cluster of stack %s: %w", stackName, err)
+	}
+	serviceArn, err := stackResourceID(ctx, cf, stackName, serviceResourceName)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the ECS service of stack %s: %w", stackName, err)
+	}
+	described, err := ecsClient.DescribeServices(ctx, &ecs.DescribeServicesInput{Cluster: &cluster, Services: []string{serviceArn}})
+	if err != nil {
+		return "", err
+	}
+	if len(described.Services) == 0 {
+		return "", fmt.Errorf("ECS service %s not found", serviceArn)
+	}
+	return primaryTaskDefinition(described.Services[0]), nil
+}
+
+// The task definition of the primary task set of an ECS service, or of the service when it has no task sets
+func primaryTaskDefinition(service ecstypes.Service) string {
+	for _, taskSet := range service.TaskSets {
+		if taskSet.Status != nil && *taskSet.Status == "PRIMARY" && taskSet.TaskDefinition != nil {
+			return *taskSet.TaskDefinition
+		}
+	}
+	if service.TaskDefinition == nil {
+		return ""
+	}
+	return *service.TaskDefinition
+}
+
+// Start a CodeDeploy deployment of the task definition of a deployed stack, when the ECS service doesn't run it yet,
+// and follow it until the traffic is shifted. Returns an error when the deployment fails or is stopped, CodeDeploy
+// having shifted the traffic back to the previous tasks.
+func BlueGreenDeploy(ctx context.Context, resources BlueGreenResources) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+
+	taskDefinition, err := stackResourceID(ctx, cf, resources.StackName, resources.TaskDefinitionResourceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the task definition of stack %s: %w", resources.StackName, err)
+	}
+	running, err := serviceTaskDefinition(ctx, cf, ecs.NewFromConfig(cfg), resources.StackName, resources.ClusterResourceName, resources.ServiceResourceName)
+	if err != nil {
+		return err
+	}
+	if running == taskDefinition {
+		log.Printf("[info] [stack: %s] The service already runs task definition %s", resources.StackName, taskDefinition)
+		return nil
+	}
+	return deployTaskDefinition(ctx, cf, codedeploy.NewFromConfig(cfg), resources, taskDefinition, nil)
+}
+
+// Shift the traffic of a service deployed blue/green back to a previous task definition at once, and follow the
+// deployment until it is done
+func BlueGreenRollback(ctx context.Context, resources BlueGreenResources, taskDefinition string) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	return deployTaskDefinition(ctx, cloudformation.NewFromConfig(cfg), codedeploy.NewFromConfig(cfg), resources, taskDefinition, ptr("CodeDeployDefault.ECSAllAtOnce"))
+}
+
+// Start a CodeDeploy deployment of a task definition and follow it. The traffic is shifted as set by the
+// deployment group unless another deployment configuration is given.
+func deployTaskDefinition(ctx context.Context, cf *cloudformation.Client, deployClient *codedeploy.Client, resources BlueGreenResources, taskDefinition string, deploymentConfigName *string) error {
+	application, err := stackResourceID(ctx, cf, resources.StackName, resources.ApplicationResourceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the CodeDeploy application of stack %s: %w", resources.StackName, err)
+	}
+	deploymentGroup, err := stackResourceID(ctx, cf, resources.StackName, resources.DeploymentGroupResourceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the CodeDeploy deployment group of stack %s: %w", resources.StackName, err)
+	}
+
+	appSpec, err := json.Marshal(map[string]any{
+		"version": 1,
+		"Resources": []any{
+			map[string]any{
+				"TargetService": map[string]any{
+					"Type": "AWS::ECS::Service",
+					"Properties": map[string]any{
+						"TaskDefinition": taskDefinition,
+						"LoadBalancerInfo": map[string]any{
+							"ContainerName": resources.ContainerName,
+							"ContainerPort": resources.ContainerPort,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	created, err := deployClient.CreateDeployment(ctx, &codedeploy.CreateDeploymentInput{
+		ApplicationName:      &application,
+		DeploymentGroupName:  &deploymentGroup,
+		DeploymentConfigName: deploymentConfigName,
+		Description:          ptr(fmt.Sprintf("Deploy %s with autodock", taskDefinition)),
+		Revision: &codedeploytypes.RevisionLocation{
+			RevisionType:   codedeploytypes.RevisionLocationTypeAppSpecContent,
+			AppSpecContent: &codedeploytypes.AppSpecContent{Content: ptr(string(appSpec))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start the CodeDeploy deployment of stack %s: %w", resources.StackName, err)
+	}
+	deploymentID := *created.DeploymentId
+	log.Printf("[info] [stack: %s] Started CodeDeploy deployment %s of task definition %s", resources.StackName, deploymentID, taskDefinition)
+
+	return followDeployment(ctx, deployClient, resources.StackName, deploymentID)
+}
+
+// Print the status of a CodeDeploy deployment and the share of the traffic of its new tasks until it ends
+func followDeployment(ctx context.Context, deployClient *codedeploy.Client, stackName string, deploymentID string) error {
+	lastStatus, lastTraffic := "", ""
+	for {
+		deployment, err := deployClient.GetDeployment(ctx, &codedeploy.GetDeploymentInput{DeploymentId: &deploymentID})
+		if err != nil {
+			return err
+		}
+		info := deployment.DeploymentInfo
+		if status := string(info.Status); status != lastStatus {
+			log.Printf("[info] [stack: %s] Deployment %s is %s", stackName, deploymentID, status)
+			lastStatus = status
+		}
+		if traffic := deploymentTraffic(ctx, deployClient, deploymentID); traffic != "" && traffic != lastTraffic {
+			log.Printf("[info] [stack: %s] %s", stackName, traffic)
+			lastTraffic = traffic
+		}
+
+		switch info.Status {
+		case codedeploytypes.DeploymentStatusSucceeded:
+			return nil
+		case codedeploytypes.DeploymentStatusFailed, codedeploytypes.DeploymentStatusStopped:
+			reasons := []string{}
+			if info.ErrorInformation != nil && info.ErrorInformation.Message != nil {
+				reasons = append(reasons, *info.ErrorInformation.Message)
+			}
+			if info.RollbackInfo != nil && info.RollbackInfo.RollbackMessage != nil {
+				reasons = append(reasons, *info.RollbackInfo.RollbackMessage)
+			}
+			return fmt.Errorf("deployment %s of stack %s is %s, the traffic stays on the previous tasks: %s", deploymentID, stackName, strings.ToLower(lastStatus), strings.Join(reasons, "; "))
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// Share of the traffic and running tasks of each task set of an ECS deployment, e.g. "Blue: 90% of the traffic, 2
+// tasks; Green: 10% of the traffic, 2 tasks". Empty while the task sets aren't known yet.
+func deploymentTraffic(ctx context.Context, deployClient *codedeploy.Client, deploymentID string) string {
+	targets, err := deployClient.ListDeploymentTargets(ctx, &codedeploy.ListDeploymentTargetsInput{DeploymentId: &deploymentID})
+	if err != nil || len(targets.TargetIds) == 0 {
+		return ""
+	}
+	target, err := deployClient.GetDeploymentTarget(ctx, &codedeploy.GetDeploymentTargetInput{DeploymentId: &deploymentID, TargetId: &targets.TargetIds[0]})
+	if err != nil || target.DeploymentTarget.EcsTarget == nil {
+		return ""
+	}
+	taskSets := []string{}
+	for _, taskSet := range target.DeploymentTarget.EcsTarget.TaskSetsInfo {
+		taskSets = append(taskSets, fmt.Sprintf("%s: %.0f%% of the traffic, %d tasks", taskSet.TaskSetLabel, taskSet.TrafficWeight, taskSet.RunningCount))
+	}
+	return strings.Join(taskSets, "; ")
+}
@@ -0,0 +1,171 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Most stopped-task reasons reported for a failed deployment, the tasks of a failing service usually all stop for
+// the same one
+const maxStoppedTaskReasons = 5
+
+// Longest wait for the tasks of a rolled back service to be stable
+const rollbackTimeout = 15 * time.Minute
+
+// Log why a stack deployment started at the given time failed: the reasons of the resources that failed, whether ECS
+// rolled back a service with its deployment circuit breaker, and why the tasks of its clusters stopped
+func reportStackFailure(ctx context.Context, cf *cloudformation.Client, ecsClient *ecs.Client, stackName string, started time.Time) {
+	reasons, err := stackFailureReasons(ctx, cf, stackName, started)
+	if err != nil {
+		log.Printf("[warn] [stack: %s] Failed to read the events of the stack: %s", stackName, err)
+	}
+	for _, reason := range reasons {
+		log.Printf("[error] [stack: %s] %s", stackName, reason)
+	}
+	if slices.ContainsFunc(reasons, func(reason string) bool { return containsIgnoreCase(reason, "circuit breaker") }) {
+		log.Printf("[error] [stack: %s] The tasks of the new deployment kept failing: ECS stopped it and rolled the service back to its previous task definition", stackName)
+	}
+
+	stopped, err := stoppedTaskReasons(ctx, ecsClient, cf, stackName, started)
+	if err != nil {
+		log.Printf("[warn] [stack: %s] Failed to read the stopped tasks of the stack: %s", stackName, err)
+	}
+	for _, reason := range stopped {
+		log.Printf("[error] [stack: %s] %s", stackName, reason)
+	}
+}
+
+// Status reasons of the resources of a stack that failed since a time, oldest first. Resources cancelled because
+// another one failed are left out.
+func stackFailureReasons(ctx context.Context, cf *cloudformation.Client, stackName string, since time.Time) ([]string, error) {
+	reasons := []string{}
+	paginator := cloudformation.NewDescribeStackEventsPaginator(cf, &cloudformation.DescribeStackEventsInput{StackName: &stackName})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// events are listed newest first
+		for _, event := range page.StackEvents {
+			if event.Timestamp.Before(since) {
+				slices.Reverse(reasons)
+				return reasons, nil
+			}
+			if !strings.HasSuffix(string(event.ResourceStatus), "FAILED") || event.ResourceStatusReason == nil {
+				continue
+			}
+			reason := *event.ResourceStatusReason
+			if containsIgnoreCase(reason, "cancelled") || *event.LogicalResourceId == stackName {
+				continue
+			}
+			reasons = append(reasons, fmt.Sprintf("%s failed: %s", *event.LogicalResourceId, reason))
+		}
+	}
+	slices.Reverse(reasons)
+	return reasons, nil
+}
+
+// Why the tasks of the ECS clusters of a stack stopped since a time, newest first, with the exit code of their
+// containers, each distinct reason once
+func stoppedTaskReasons(ctx context.Context, ecsClient *ecs.Client, cf *cloudformation.Client, stackName string, since time.Time) ([]string, error) {
+	resources, err := cf.DescribeStackResources(ctx, &cloudformation.DescribeStackResourcesInput{StackName: &stackName})
+	if err != nil {
+		return nil, err
+	}
+	stopped := []ecstypes.Task{}
+	for _, resource := range resources.StackResources {
+		if *resource.ResourceType != "AWS::ECS::Cluster" || resource.PhysicalResourceId == nil {
+			continue
+		}
+		cluster := *resource.PhysicalResourceId
+		paginator := ecs.NewListTasksPaginator(ecsClient, &ecs.ListTasksInput{
+			Cluster:       &cluster,
+			DesiredStatus: ecstypes.DesiredStatusStopped,
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if len(page.TaskArns) == 0 {
+				continue
+			}
+			// a page holds at most 100 tasks, as many as DescribeTasks takes
+			described, err := ecsClient.DescribeTasks(ctx, &ecs.DescribeTasksInput{Cluster: &cluster, Tasks: page.TaskArns})
+			if err != nil {
+				return nil, err
+			}
+			for _, task := range described.Tasks {
+				if task.StoppedAt != nil && !task.StoppedAt.Before(since) && task.StoppedReason != nil {
+					stopped = append(stopped, task)
+				}
+			}
+		}
+	}
+	slices.SortFunc(stopped, func(a, b ecstypes.Task) int { return b.StoppedAt.Compare(*a.StoppedAt) })
+
+	reasons := []string{}
+	for _, task := range stopped {
+		reason := fmt.Sprintf("Task stopped: %s", *task.StoppedReason)
+		for _, container := range task.Containers {
+			switch {
+			case container.ExitCode != nil && *container.ExitCode != 0:
+				reason += fmt.Sprintf("; container %s exited with code %d", *container.Name, *container.ExitCode)
+			case container.Reason != nil:
+				reason += fmt.Sprintf("; container %s: %s", *container.Name, *container.Reason)
+			}
+		}
+		if !slices.Contains(reasons, reason) && len(reasons) < maxStoppedTaskReasons {
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons, nil
+}
+
+// Get the task definition the ECS service of a deployed stack runs, empty when the stack doesn't exist yet
+func CurrentTaskDefinition(ctx context.Context, resources ServiceResources) (string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	if !stackExists(ctx, cf, resources.StackName) {
+		return "", nil
+	}
+	return serviceTaskDefinition(ctx, cf, ecs.NewFromConfig(cfg), resources.StackName, resources.ClusterResourceName, resources.ServiceResourceName)
+}
+
+// Deploy a previous task definition to the ECS service of a deployed stack and wait until its tasks are stable. The
+// stack keeps the new task definition, the next deploy replaces the previous one again.
+func RollbackService(ctx context.Context, resources ServiceResources, taskDefinition string) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	ecsClient := ecs.NewFromConfig(cfg)
+
+	cluster, err := stackResourceID(ctx, cf, resources.StackName, resources.ClusterResourceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the cluster of stack %s: %w", resources.StackName, err)
+	}
+	serviceArn, err := stackResourceID(ctx, cf, resources.StackName, resources.ServiceResourceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the ECS service of stack %s: %w", resources.StackName, err)
+	}
+	if _, err := ecsClient.UpdateService(ctx, &ecs.UpdateServiceInput{Cluster: &cluster, Service: &serviceArn, TaskDefinition: &taskDefinition}); err != nil {
+		return fmt.Errorf("failed to roll back the ECS service of stack %s: %w", resources.StackName, err)
+	}
+	log.Printf("[info] [stack: %s] Rolling back to task definition %s", resources.StackName, taskDefinition)
+	waiter := ecs.NewServicesStableWaiter(ecsClient)
+	return waiter.Wait(ctx, &ecs.DescribeServicesInput{Cluster: &cluster, Services: []string{serviceArn}}, rollbackTimeout)
+}
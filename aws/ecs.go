@@ -0,0 +1,247 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cloudwatchlogstypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// A one-off task started from the task definition of a deployed service
+type OneOffTask struct {
+	StackName                  string
+	ClusterResourceName        string
+	TaskDefinitionResourceName string
+	ContainerName              string
+	SubnetExports              []string // bootstrap exports of the subnets to run the task in
+	SecurityGroupExport        string
+	AssignPublicIP             bool               // when the subnets are public, for the task to reach the internet
+	Command                    []string           // overrides the command of the container when not empty
+	CapacityProviders          []CapacityProvider // places the task as the service does, on the FARGATE launch type when empty
+}
+
+// A capacity provider of a strategy, FARGATE or FARGATE_SPOT
+type CapacityProvider struct {
+	Provider string
+	Base     int
+	Weight   int
+}
+
+// Get the values of CloudFormation exports by name
+func exportValues(ctx context.Context, cf *cloudformation.Client, names ...string) (map[string]string, error) {
+	values := map[string]string{}
+	paginator := cloudformation.NewListExportsPaginator(cf, &cloudformation.ListExportsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, export := range page.Exports {
+			values[*export.Name] = *export.Value
+		}
+	}
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("export %s not found, has the bootstrap stack been deployed?", name)
+		}
+	}
+	return values, nil
+}
+
+// Start a one-off Fargate task, stream the logs of its container to output until it stops, and return the exit code of the container.
+// The task is stopped if ctx is cancelled before it ends.
+func RunTask(ctx context.Context, task OneOffTask, output io.Writer) (int, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	ecsClient := ecs.NewFromConfig(cfg)
+
+	cluster, err := stackResourceID(ctx, cf, task.StackName, task.ClusterResourceName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the cluster of stack %s: %w", task.StackName, err)
+	}
+	taskDefinition, err := stackResourceID(ctx, cf, task.StackName, task.TaskDefinitionResourceName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the task definition of stack %s: %w", task.StackName, err)
+	}
+	exports, err := exportValues(ctx, cf, append(append([]string{}, task.SubnetExports...), task.SecurityGroupExport)...)
+	if err != nil {
+		return 0, err
+	}
+	subnets := []string{}
+	for _, subnetExport := range task.SubnetExports {
+		subnets = append(subnets, exports[subnetExport])
+	}
+
+	logGroup, logStreamPrefix, err := containerLogConfiguration(ctx, ecsClient, taskDefinition, task.ContainerName)
+	if err != nil {
+		return 0, err
+	}
+
+	input := &ecs.RunTaskInput{
+		Cluster:        &cluster,
+		TaskDefinition: &taskDefinition,
+		LaunchType:     ecstypes.LaunchTypeFargate,
+		StartedBy:      ptr("autodock"),
+		NetworkConfiguration: &ecstypes.NetworkConfiguration{
+			AwsvpcConfiguration: &ecstypes.AwsVpcConfiguration{
+				Subnets:        subnets,
+				SecurityGroups: []string{exports[task.SecurityGroupExport]},
+				AssignPublicIp: ecstypes.AssignPublicIpDisabled,
+			},
+		},
+	}
+	if len(task.CapacityProviders) > 0 {
+		input.LaunchType = ""
+		for _, provider := range task.CapacityProviders {
+			input.CapacityProviderStrategy = append(input.CapacityProviderStrategy, ecstypes.CapacityProviderStrategyItem{
+				CapacityProvider: ptr(provider.Provider),
+				Base:             int32(provider.Base),
+				Weight:           int32(provider.Weight),
+			})
+		}
+	}
+	if task.AssignPublicIP {
+		input.NetworkConfiguration.AwsvpcConfiguration.AssignPublicIp = ecstypes.AssignPublicIpEnabled
+	}
+	if len(task.Command) > 0 {
+		input.Overrides = &ecstypes.TaskOverride{
+			ContainerOverrides: []ecstypes.ContainerOverride{
+				{Name: &task.ContainerName, Command: task.Command},
+			},
+		}
+	}
+	runOutput, err := ecsClient.RunTask(ctx, input)
+	if err != nil {
+		return 0, fmt.Errorf("failed to run task: %w", err)
+	}
+	if len(runOutput.Tasks) == 0 {
+		reasons := []string{}
+		for _, failure := range runOutput.Failures {
+			reasons = append(reasons, fmt.Sprintf("%s (%s)", *failure.Reason, *failure.Arn))
+		}
+		return 0, fmt.Errorf("failed to run task: %s", strings.Join(reasons, ", "))
+	}
+	taskArn := *runOutput.Tasks[0].TaskArn
+	taskID := taskArn[strings.LastIndex(taskArn, "/")+1:]
+	log.Printf("[info] [stack: %s] Started task %s", task.StackName, taskID)
+
+	// the awslogs driver writes to <prefix>/<container name>/<task id>
+	logStream := fmt.Sprintf("%s/%s/%s", logStreamPrefix, task.ContainerName, taskID)
+	logs := &logStreamReader{client: cloudwatchlogs.NewFromConfig(cfg), logGroup: logGroup, logStream: logStream}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the caller is gone, don't leave the task running on its own
+			stopCtx := context.Background()
+			if _, err := ecsClient.StopTask(stopCtx, &ecs.StopTaskInput{Cluster: &cluster, Task: &taskArn, Reason: ptr("Interrupted from autodock")}); err != nil {
+				log.Printf("[warn] Failed to stop task %s: %s", taskID, err)
+			} else {
+				log.Printf("[info] Stopped task %s", taskID)
+			}
+			return 0, ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+
+		if err := logs.copyTo(ctx, output); err != nil {
+			return 0, err
+		}
+
+		described, err := ecsClient.DescribeTasks(ctx, &ecs.DescribeTasksInput{Cluster: &cluster, Tasks: []string{taskArn}})
+		if err != nil {
+			return 0, err
+		}
+		if len(described.Tasks) == 0 || awssdk.ToString(described.Tasks[0].LastStatus) != string(ecstypes.DesiredStatusStopped) {
+			continue
+		}
+
+		// the last lines can reach CloudWatch after the task has stopped
+		time.Sleep(5 * time.Second)
+		if err := logs.copyTo(ctx, output); err != nil {
+			return 0, err
+		}
+		stopped := described.Tasks[0]
+		for _, container := range stopped.Containers {
+			if *container.Name == task.ContainerName && container.ExitCode != nil {
+				return int(*container.ExitCode), nil
+			}
+		}
+		reason := "unknown reason"
+		if stopped.StoppedReason != nil {
+			reason = *stopped.StoppedReason
+		}
+		return 0, fmt.Errorf("task %s stopped without running its container: %s", taskID, reason)
+	}
+}
+
+// Get the awslogs group and stream prefix of a container in a task definition
+func containerLogConfiguration(ctx context.Context, ecsClient *ecs.Client, taskDefinition string, containerName string) (string, string, error) {
+	described, err := ecsClient.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{TaskDefinition: &taskDefinition})
+	if err != nil {
+		return "", "", err
+	}
+	for _, container := range described.TaskDefinition.ContainerDefinitions {
+		if *container.Name != containerName {
+			continue
+		}
+		if container.LogConfiguration == nil || container.LogConfiguration.LogDriver != ecstypes.LogDriverAwslogs {
+			return "", "", fmt.Errorf("container %s doesn't log to CloudWatch", containerName)
+		}
+		options := container.LogConfiguration.Options
+		return options["awslogs-group"], options["awslogs-stream-prefix"], nil
+	}
+	return "", "", fmt.Errorf("container %s not found in task definition %s", containerName, taskDefinition)
+}
+
+// Reads a log stream incrementally, remembering where the previous read stopped
+type logStreamReader struct {
+	client    *cloudwatchlogs.Client
+	logGroup  string
+	logStream string
+	nextToken *string
+}
+
+// Write the log lines received since the previous call
+func (r *logStreamReader) copyTo(ctx context.Context, output io.Writer) error {
+	for {
+		page, err := r.client.GetLogEvents(ctx, &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  &r.logGroup,
+			LogStreamName: &r.logStream,
+			StartFromHead: ptr(true),
+			NextToken:     r.nextToken,
+		})
+		if err != nil {
+			// the stream is only created once the container writes its first line
+			var notFound *cloudwatchlogstypes.ResourceNotFoundException
+			if errors.As(err, &notFound) {
+				return nil
+			}
+			return err
+		}
+		for _, event := range page.Events {
+			fmt.Fprintln(output, *event.Message)
+		}
+		// the same token is returned once the end of the stream is reached
+		if r.nextToken != nil && *page.NextForwardToken == *r.nextToken {
+			return nil
+		}
+		r.nextToken = page.NextForwardToken
+		if len(page.Events) == 0 {
+			return nil
+		}
+	}
+}
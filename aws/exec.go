@@ -0,0 +1,123 @@
+package aws
+
+import (
+	"autodock/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// Open an interactive ECS Exec session running command in the container of a running task of a deployed service.
+// The session is handled by the Session Manager plugin, which must be installed, attached to the terminal.
+// Only the cluster, service and container of resources are used.
+func ExecCommand(ctx context.Context, resources ServiceResources, command []string) error {
+	pluginPath, err := exec.LookPath("session-manager-plugin")
+	if err != nil {
+		return fmt.Errorf("session-manager-plugin not found, install it from https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	ecsClient := ecs.NewFromConfig(cfg)
+
+	cluster, err := stackResourceID(ctx, cf, resources.StackName, resources.ClusterResourceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the cluster of stack %s: %w", resources.StackName, err)
+	}
+	serviceArn, err := stackResourceID(ctx, cf, resources.StackName, resources.ServiceResourceName)
+	if err != nil {
+		return fmt.Errorf("failed to find the ECS service of stack %s: %w", resources.StackName, err)
+	}
+	serviceName := serviceArn[strings.LastIndex(serviceArn, "/")+1:]
+	tasks, err := ecsClient.ListTasks(ctx, &ecs.ListTasksInput{Cluster: &cluster, ServiceName: &serviceName, DesiredStatus: ecstypes.DesiredStatusRunning})
+	if err != nil {
+		return err
+	}
+	if len(tasks.TaskArns) == 0 {
+		return fmt.Errorf("no running task in stack %s", resources.StackName)
+	}
+	described, err := ecsClient.DescribeTasks(ctx, &ecs.DescribeTasksInput{Cluster: &cluster, Tasks: tasks.TaskArns})
+	if err != nil {
+		return err
+	}
+
+	// pick the first task whose container is running with the exec agent
+	var task *ecstypes.Task
+	var runtimeID string
+	for i, candidate := range described.Tasks {
+		if !candidate.EnableExecuteCommand {
+			continue
+		}
+		for _, container := range candidate.Containers {
+			if awssdk.ToString(container.Name) == resources.ContainerName && container.RuntimeId != nil && awssdk.ToString(container.LastStatus) == "RUNNING" {
+				task, runtimeID = &described.Tasks[i], *container.RuntimeId
+			}
+		}
+		if task != nil {
+			break
+		}
+	}
+	if task == nil {
+		return fmt.Errorf("no running task of stack %s has exec enabled, set exec: true in x-autodock and deploy again", resources.StackName)
+	}
+	taskID := (*task.TaskArn)[strings.LastIndex(*task.TaskArn, "/")+1:]
+	log.Printf("[info] [stack: %s] Opening a session in task %s", resources.StackName, taskID)
+
+	// the agent splits the command line like a shell, quoted arguments keep their spaces
+	output, err := ecsClient.ExecuteCommand(ctx, &ecs.ExecuteCommandInput{
+		Cluster:     &cluster,
+		Task:        task.TaskArn,
+		Container:   &resources.ContainerName,
+		Command:     ptr(utils.ShellJoin(command)),
+		Interactive: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	// same arguments as the AWS CLI passes to the plugin
+	session, err := json.Marshal(map[string]string{
+		"sessionId":  *output.Session.SessionId,
+		"streamUrl":  *output.Session.StreamUrl,
+		"tokenValue": *output.Session.TokenValue,
+	})
+	if err != nil {
+		return err
+	}
+	target, err := json.Marshal(map[string]string{
+		"Target": fmt.Sprintf("ecs:%s_%s_%s", cluster, taskID, runtimeID),
+	})
+	if err != nil {
+		return err
+	}
+	plugin := exec.CommandContext(ctx, pluginPath,
+		string(session),
+		cfg.Region,
+		"StartSession",
+		os.Getenv("AWS_PROFILE"),
+		string(target),
+		fmt.Sprintf("https://ecs.%s.amazonaws.com", cfg.Region),
+	)
+	plugin.Stdin, plugin.Stdout, plugin.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// Ctrl-C is forwarded to the remote command by the plugin, it must not stop autodock.
+	// Signals are caught rather than ignored, as ignored signals would also be ignored by the plugin.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	return plugin.Run()
+}
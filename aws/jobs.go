@@ -0,0 +1,95 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// A run of a scheduled job, read from the ECS event recorded when its task stopped
+type JobRun struct {
+	TaskArn   string    `json:"taskArn"`
+	StartedAt time.Time `json:"startedAt"`
+	StoppedAt time.Time `json:"stoppedAt"`
+	ExitCode  *int      `json:"exitCode"` // nil when the container never ran, e.g. the image couldn't be pulled
+	Reason    string    `json:"reason,omitempty"`
+}
+
+func (r JobRun) Failed() bool {
+	return r.ExitCode == nil || *r.ExitCode != 0
+}
+
+// The parts of an "ECS Task State Change" event describing how a task ended
+type taskStateChangeEvent struct {
+	Detail struct {
+		TaskArn       string    `json:"taskArn"`
+		StartedAt     time.Time `json:"startedAt"`
+		StoppedAt     time.Time `json:"stoppedAt"`
+		StoppedReason string    `json:"stoppedReason"`
+		Containers    []struct {
+			Name     string `json:"name"`
+			ExitCode *int   `json:"exitCode"`
+			Reason   string `json:"reason"`
+		} `json:"containers"`
+	} `json:"detail"`
+}
+
+// Get the runs of a scheduled job since the given time, newest first, with the exit code of its container of the
+// given name, its sidecars exiting on their own. They are read from the log group created by its stack, given by its
+// logical ID.
+func JobRuns(ctx context.Context, stackName string, logGroupResourceName string, containerName string, since time.Time) ([]JobRun, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	logGroupName, err := stackResourceID(ctx, cf, stackName, logGroupResourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []JobRun{}
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(cloudwatchlogs.NewFromConfig(cfg), &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: &logGroupName,
+		StartTime:    ptr(since.UnixMilli()),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, logEvent := range page.Events {
+			var event taskStateChangeEvent
+			if err := json.Unmarshal([]byte(*logEvent.Message), &event); err != nil {
+				log.Printf("[warn] [stack: %s] Skipping unreadable job run event: %s", stackName, err)
+				continue
+			}
+			run := JobRun{
+				TaskArn:   event.Detail.TaskArn,
+				StartedAt: event.Detail.StartedAt,
+				StoppedAt: event.Detail.StoppedAt,
+				Reason:    event.Detail.StoppedReason,
+			}
+			for _, container := range event.Detail.Containers {
+				if container.Name != containerName {
+					continue
+				}
+				run.ExitCode = container.ExitCode
+				if container.Reason != "" {
+					run.Reason = fmt.Sprintf("%s: %s", container.Name, container.Reason)
+				}
+			}
+			runs = append(runs, run)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StoppedAt.After(runs[j].StoppedAt) })
+	return runs, nil
+}
@@ -0,0 +1,120 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// The log group of a deployed service, given by the logical ID of its stack resource
+type LogSource struct {
+	Service              string
+	StackName            string
+	LogGroupResourceName string
+}
+
+// A line written by the container of a service
+type LogEvent struct {
+	Service   string    `json:"service"`
+	Timestamp time.Time `json:"timestamp"`
+	Stream    string    `json:"stream"`
+	Message   string    `json:"message"`
+}
+
+// Read the log events of services since the given time, in chronological order across services.
+// Only the events matching filter are read when it is not empty, see the CloudWatch Logs filter pattern syntax.
+// When follow is true, new events keep being polled until ctx is cancelled.
+func TailLogs(ctx context.Context, sources []LogSource, since time.Time, filter string, follow bool, handle func(LogEvent)) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	logsClient := cloudwatchlogs.NewFromConfig(cfg)
+
+	groups := []*logGroupTail{}
+	for _, source := range sources {
+		logGroupName, err := stackResourceID(ctx, cf, source.StackName, source.LogGroupResourceName)
+		if err != nil {
+			return fmt.Errorf("failed to find the log group of %s: %w", source.Service, err)
+		}
+		groups = append(groups, &logGroupTail{service: source.Service, logGroup: logGroupName, start: since.UnixMilli(), seen: map[string]bool{}})
+	}
+
+	for {
+		events := []LogEvent{}
+		for _, group := range groups {
+			groupEvents, err := group.next(ctx, logsClient, filter)
+			if err != nil {
+				return fmt.Errorf("failed to read the logs of %s: %w", group.service, err)
+			}
+			events = append(events, groupEvents...)
+		}
+		sort.SliceStable(events, func(i, j int) bool {
+			return events[i].Timestamp.Before(events[j].Timestamp)
+		})
+		for _, event := range events {
+			handle(event)
+		}
+
+		if !follow {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// Reads a log group incrementally. Events are polled again from the timestamp of the last one,
+// as more events can arrive with that same timestamp, so the IDs of the events read at that timestamp are kept.
+type logGroupTail struct {
+	service  string
+	logGroup string
+	start    int64 // milliseconds since the epoch
+	seen     map[string]bool
+}
+
+// Get the events written since the previous call
+func (t *logGroupTail) next(ctx context.Context, client *cloudwatchlogs.Client, filter string) ([]LogEvent, error) {
+	input := &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: &t.logGroup,
+		StartTime:    ptr(t.start),
+	}
+	if filter != "" {
+		input.FilterPattern = &filter
+	}
+	events := []LogEvent{}
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, logEvent := range page.Events {
+			if t.seen[*logEvent.EventId] {
+				continue
+			}
+			if *logEvent.Timestamp > t.start {
+				t.start = *logEvent.Timestamp
+				t.seen = map[string]bool{}
+			}
+			t.seen[*logEvent.EventId] = true
+			events = append(events, LogEvent{
+				Service:   t.service,
+				Timestamp: time.UnixMilli(*logEvent.Timestamp),
+				Stream:    *logEvent.LogStreamName,
+				Message:   *logEvent.Message,
+			})
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,92 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+)
+
+// A public Route53 hosted zone, and the name servers the domain must be delegated to
+type HostedZone struct {
+	ID          string
+	Name        string
+	NameServers []string
+}
+
+// Find the IDs of the public hosted zones of a domain. There is usually one, several meaning that the domain is
+// delegated to one of them and the others are unused.
+func LookupHostedZones(ctx context.Context, domain string) ([]string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := route53.NewFromConfig(cfg)
+
+	name := strings.TrimSuffix(domain, ".") + "."
+	ids := []string{}
+	input := &route53.ListHostedZonesByNameInput{DNSName: &name}
+	for {
+		output, err := client.ListHostedZonesByName(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up the hosted zone of %s: %w", domain, err)
+		}
+		// zones are sorted by name, the ones of the domain come first
+		for _, zone := range output.HostedZones {
+			if *zone.Name != name {
+				return ids, nil
+			}
+			if zone.Config == nil || !zone.Config.PrivateZone {
+				ids = append(ids, strings.TrimPrefix(*zone.Id, "/hostedzone/"))
+			}
+		}
+		if !output.IsTruncated {
+			return ids, nil
+		}
+		input.DNSName, input.HostedZoneId = output.NextDNSName, output.NextHostedZoneId
+	}
+}
+
+// Create a public hosted zone for a domain, returning the name servers to delegate the domain to
+func CreateHostedZone(ctx context.Context, domain string) (*HostedZone, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := route53.NewFromConfig(cfg)
+
+	output, err := client.CreateHostedZone(ctx, &route53.CreateHostedZoneInput{
+		Name:            &domain,
+		CallerReference: ptr(fmt.Sprintf("autodock-%s-%d", domain, time.Now().UnixNano())),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the hosted zone of %s: %w", domain, err)
+	}
+	zone := &HostedZone{
+		ID:   strings.TrimPrefix(*output.HostedZone.Id, "/hostedzone/"),
+		Name: domain,
+	}
+	if output.DelegationSet != nil {
+		zone.NameServers = output.DelegationSet.NameServers
+	}
+	return zone, nil
+}
+
+// Whether a stack manages a resource, false when the stack or the resource doesn't exist
+func StackHasResource(ctx context.Context, stackName string, logicalID string) bool {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	if !stackExists(ctx, cf, stackName) {
+		return false
+	}
+	_, err = stackResourceID(ctx, cf, stackName, logicalID)
+	return err == nil
+}
@@ -0,0 +1,111 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cloudfronttypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Upload the files of a directory to a bucket, keyed by their path in the directory, and delete the objects of
+// files that no longer exist. Returns the number of files uploaded.
+func UploadStatic(ctx context.Context, bucket string, dir string) (int, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := s3.NewFromConfig(cfg)
+
+	keys := map[string]bool{}
+	err = filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		body, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		contentType := mime.TypeByExtension(path.Ext(key))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      &bucket,
+			Key:         &key,
+			Body:        body,
+			ContentType: &contentType,
+		}); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", key, err)
+		}
+		keys[key] = true
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload %s to %s: %w", dir, bucket, err)
+	}
+
+	stale := []s3types.ObjectIdentifier{}
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: &bucket})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list the objects of %s: %w", bucket, err)
+		}
+		for _, object := range page.Contents {
+			if !keys[*object.Key] {
+				stale = append(stale, s3types.ObjectIdentifier{Key: object.Key})
+			}
+		}
+	}
+	// DeleteObjects takes up to 1000 keys
+	for start := 0; start < len(stale); start += 1000 {
+		if _, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &bucket,
+			Delete: &s3types.Delete{Objects: stale[start:min(start+1000, len(stale))], Quiet: ptr(true)},
+		}); err != nil {
+			return 0, fmt.Errorf("failed to delete the removed files from %s: %w", bucket, err)
+		}
+	}
+	return len(keys), nil
+}
+
+// Invalidate every cached path of a CloudFront distribution, so that it serves the files just uploaded
+func InvalidateDistribution(ctx context.Context, distributionID string) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := cloudfront.NewFromConfig(cfg)
+
+	_, err = client.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: &distributionID,
+		InvalidationBatch: &cloudfronttypes.InvalidationBatch{
+			CallerReference: ptr(fmt.Sprintf("autodock-%d", time.Now().UnixNano())),
+			Paths: &cloudfronttypes.Paths{
+				Quantity: ptr(int32(1)),
+				Items:    []string{"/*"},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invalidate the distribution %s: %w", distributionID, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+)
+
+// The resources of a deployed service to report on, given by the logical IDs of its stack resources
+type ServiceResources struct {
+	StackName               string
+	ClusterResourceName     string
+	ServiceResourceName     string
+	TargetGroupResourceName string
+	ContainerName           string
+}
+
+// The state of the ECS service of a deployed service and of its load balancer targets
+type ServiceStatus struct {
+	Desired     int32          `json:"desired"`
+	Running     int32          `json:"running"`
+	Pending     int32          `json:"pending"`
+	Image       string         `json:"image"`                 // image of the container in the current task definition
+	ImageDigest string         `json:"imageDigest,omitempty"` // digest of the image run by the tasks, empty when none is running
+	Targets     []TargetHealth `json:"targets"`
+}
+
+// The health of a task registered in the target group of a load balancer
+type TargetHealth struct {
+	ID     string `json:"id"`
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Count the targets in each health state
+func (s ServiceStatus) TargetStates() map[string]int {
+	states := map[string]int{}
+	for _, target := range s.Targets {
+		states[target.State]++
+	}
+	return states
+}
+
+// Get the task counts, image and target health of a deployed service
+func DescribeService(ctx context.Context, resources ServiceResources) (*ServiceStatus, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	cf := cloudformation.NewFromConfig(cfg)
+	ecsClient := ecs.NewFromConfig(cfg)
+
+	cluster, err := stackResourceID(ctx, cf, resources.StackName, resources.ClusterResourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the cluster of stack %s: %w", resources.StackName, err)
+	}
+	serviceArn, err := stackResourceID(ctx, cf, resources.StackName, resources.ServiceResourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the ECS service of stack %s: %w", resources.StackName, err)
+	}
+	targetGroupArn, err := stackResourceID(ctx, cf, resources.StackName, resources.TargetGroupResourceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the target group of stack %s: %w", resources.StackName, err)
+	}
+
+	described, err := ecsClient.DescribeServices(ctx, &ecs.DescribeServicesInput{Cluster: &cluster, Services: []string{serviceArn}})
+	if err != nil {
+		return nil, err
+	}
+	if len(described.Services) == 0 {
+		return nil, fmt.Errorf("ECS service %s not found", serviceArn)
+	}
+	service := described.Services[0]
+	status := &ServiceStatus{
+		Desired: service.DesiredCount,
+		Running: service.RunningCount,
+		Pending: service.PendingCount,
+		Targets: []TargetHealth{},
+	}
+
+	// services deployed blue/green by CodeDeploy run the task definition of their primary task set, in the target group
+	// the traffic was last shifted to
+	currentTaskDefinition := primaryTaskDefinition(service)
+	for _, taskSet := range service.TaskSets {
+		if taskSet.Status != nil && *taskSet.Status == "PRIMARY" && len(taskSet.LoadBalancers) > 0 && taskSet.LoadBalancers[0].TargetGroupArn != nil {
+			targetGroupArn = *taskSet.LoadBalancers[0].TargetGroupArn
+		}
+	}
+
+	taskDefinition, err := ecsClient.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{TaskDefinition: &currentTaskDefinition})
+	if err != nil {
+		return nil, err
+	}
+	for _, container := range taskDefinition.TaskDefinition.ContainerDefinitions {
+		if *container.Name == resources.ContainerName {
+			status.Image = *container.Image
+		}
+	}
+
+	tasks, err := ecsClient.ListTasks(ctx, &ecs.ListTasksInput{Cluster: &cluster, ServiceName: service.ServiceName, DesiredStatus: ecstypes.DesiredStatusRunning})
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks.TaskArns) > 0 {
+		describedTasks, err := ecsClient.DescribeTasks(ctx, &ecs.DescribeTasksInput{Cluster: &cluster, Tasks: tasks.TaskArns})
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range describedTasks.Tasks {
+			// prefer the tasks of the current task definition, older ones are still running during a deployment
+			if *task.TaskDefinitionArn != currentTaskDefinition && status.ImageDigest != "" {
+				continue
+			}
+			for _, container := range task.Containers {
+				if *container.Name == resources.ContainerName && container.ImageDigest != nil {
+					status.ImageDigest = *container.ImageDigest
+				}
+			}
+		}
+	}
+
+	health, err := elasticloadbalancingv2.NewFromConfig(cfg).DescribeTargetHealth(ctx, &elasticloadbalancingv2.DescribeTargetHealthInput{TargetGroupArn: &targetGroupArn})
+	if err != nil {
+		return nil, err
+	}
+	for _, description := range health.TargetHealthDescriptions {
+		target := TargetHealth{ID: *description.Target.Id, State: string(description.TargetHealth.State)}
+		if description.TargetHealth.Description != nil {
+			target.Reason = *description.TargetHealth.Description
+		}
+		status.Targets = append(status.Targets, target)
+	}
+	return status, nil
+}
@@ -0,0 +1,107 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// An existing VPC and the subnets services run in
+type VPC struct {
+	ID             string
+	CIDR           string // primary range
+	PrivateSubnets []string
+	PublicSubnets  []string
+}
+
+// Find an existing VPC by its tags, and its private and public subnets by theirs.
+// Exactly one VPC must match, subnets are sorted by availability zone.
+func LookupVPC(ctx context.Context, vpcTags map[string]string, privateSubnetTags map[string]string, publicSubnetTags map[string]string) (*VPC, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	vpcs, err := client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{Filters: tagFilters(vpcTags)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the VPC: %w", err)
+	}
+	if len(vpcs.Vpcs) != 1 {
+		return nil, fmt.Errorf("expected one VPC tagged %v, found %d", vpcTags, len(vpcs.Vpcs))
+	}
+	vpc := &VPC{ID: *vpcs.Vpcs[0].VpcId, CIDR: *vpcs.Vpcs[0].CidrBlock}
+
+	vpc.PrivateSubnets, err = lookupSubnets(ctx, client, vpc.ID, privateSubnetTags)
+	if err != nil {
+		return nil, err
+	}
+	vpc.PublicSubnets, err = lookupSubnets(ctx, client, vpc.ID, publicSubnetTags)
+	if err != nil {
+		return nil, err
+	}
+	return vpc, nil
+}
+
+// The primary CIDR block of an existing VPC
+func LookupVPCCIDR(ctx context.Context, vpcID string) (string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to load AWS config: %v", err)
+	}
+	client := ec2.NewFromConfig(cfg)
+
+	vpcs, err := client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{vpcID}})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the VPC %s: %w", vpcID, err)
+	}
+	if len(vpcs.Vpcs) != 1 {
+		return "", fmt.Errorf("VPC %s not found", vpcID)
+	}
+	return *vpcs.Vpcs[0].CidrBlock, nil
+}
+
+func lookupSubnets(ctx context.Context, client *ec2.Client, vpcID string, tags map[string]string) ([]string, error) {
+	filters := append(tagFilters(tags), ec2types.Filter{Name: ptr("vpc-id"), Values: []string{vpcID}})
+	subnets := []ec2types.Subnet{}
+	paginator := ec2.NewDescribeSubnetsPaginator(client, &ec2.DescribeSubnetsInput{Filters: filters})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up the subnets of %s: %w", vpcID, err)
+		}
+		subnets = append(subnets, page.Subnets...)
+	}
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("no subnet of %s is tagged %v", vpcID, tags)
+	}
+	sort.Slice(subnets, func(i, j int) bool {
+		if *subnets[i].AvailabilityZone != *subnets[j].AvailabilityZone {
+			return *subnets[i].AvailabilityZone < *subnets[j].AvailabilityZone
+		}
+		return *subnets[i].SubnetId < *subnets[j].SubnetId
+	})
+	ids := []string{}
+	for _, subnet := range subnets {
+		ids = append(ids, *subnet.SubnetId)
+	}
+	return ids, nil
+}
+
+func tagFilters(tags map[string]string) []ec2types.Filter {
+	keys := []string{}
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	filters := []ec2types.Filter{}
+	for _, key := range keys {
+		filters = append(filters, ec2types.Filter{Name: ptr("tag:" + key), Values: []string{tags[key]}})
+	}
+	return filters
+}
@@ -3,6 +3,8 @@ package compose
 import (
 	"context"
 	"log"
+	"slices"
+	"sort"
 
 	"github.com/compose-spec/compose-go/v2/cli"
 	"github.com/compose-spec/compose-go/v2/types"
@@ -27,3 +29,68 @@ func Parse(composeFile string) *types.Project {
 
 	return project
 }
+
+// Names of the services that must run to completion before a service is deployed, from the depends_on entries
+// with `condition: service_completed_successfully` of the containers of its task, other than the ones of the task
+func PredeployHooks(project *types.Project, service *types.ServiceConfig) []string {
+	containers := TaskContainers(project, service)
+	inTask := map[string]bool{}
+	for _, container := range containers {
+		inTask[container.Service.Name] = true
+	}
+	hooks := []string{}
+	for _, container := range containers {
+		for name, dependency := range container.Service.DependsOn {
+			if dependency.Condition == types.ServiceConditionCompletedSuccessfully && !inTask[name] && !slices.Contains(hooks, name) {
+				hooks = append(hooks, name)
+			}
+		}
+	}
+	sort.Strings(hooks)
+	return hooks
+}
+
+// Services deployed to the cloud, sorted by name: the ones built from source that have a domain or a schedule,
+// or that other services wait for as pre-deploy hooks, the ones running a task with sidecars, and static sites.
+// Sidecars are deployed in the task of their service. Other services, such as databases pulled from a registry,
+// only run locally.
+func DeployedServices(project *types.Project) []types.ServiceConfig {
+	hooks := map[string]bool{}
+	for _, service := range project.Services {
+		for _, hook := range PredeployHooks(project, &service) {
+			hooks[hook] = true
+		}
+	}
+
+	services := []types.ServiceConfig{}
+	for _, name := range project.ServiceNames() {
+		service := project.Services[name]
+		config, err := ParseServiceConfig(project, &service)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		// static sites upload their files instead of building an image
+		if config.IsStatic() {
+			services = append(services, service)
+			continue
+		}
+		if IsSidecar(project, &service) {
+			continue
+		}
+		// the image of a service running a task is pulled when it has no build
+		if service.Build == nil && taskName(&service) == "" {
+			if hooks[name] {
+				log.Printf("[warn] Not deploying service %s: services wait for it to complete but it has no build section", name)
+			} else {
+				log.Printf("[debug] Not deploying service %s: it has no build section", name)
+			}
+			continue
+		}
+		if config.PrimaryDomain() == "" && config.Schedule == "" && !hooks[name] {
+			log.Printf("[warn] Not deploying service %s: set %s.domain to serve it or %s.schedule to run it as a job", name, ExtensionKey, ExtensionKey)
+			continue
+		}
+		services = append(services, service)
+	}
+	return services
+}
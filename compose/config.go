@@ -0,0 +1,1255 @@
+package compose
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"autodock/utils"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the Compose extension holding autodock settings, both at the top of the file and in each service
+const ExtensionKey = "x-autodock"
+
+// Settings from the top-level x-autodock block of a Compose file
+type ProjectConfig struct {
+	Defaults  *ServiceConfig   `yaml:"defaults,omitempty" desc:"Settings applied to every service that doesn't set them itself. domain, path, task, essential, schedule and timezone can only be set per service."`
+	Resources []ResourceConfig `yaml:"resources,omitempty" desc:"S3 buckets, SQS queues, SNS topics and DynamoDB tables created for the services, in addition to the ones created by the LocalStack init hooks"`
+	VPC       *VPCConfig       `yaml:"vpc,omitempty" desc:"Network the services run in, a VPC created by the bootstrap stack or an existing one"`
+	Domains   []DomainConfig   `yaml:"domains,omitempty" desc:"Hosted zones and certificates of the root domains of the services. By default the public hosted zone of a root domain is looked up in Route53, and a certificate is created and validated in it."`
+}
+
+// The hosted zone and certificate of a root domain
+type DomainConfig struct {
+	Name             string `yaml:"name" desc:"Root domain, e.g. example.com"`
+	HostedZoneID     string `yaml:"hosted_zone_id,omitempty" desc:"ID of the hosted zone of the domain, instead of looking it up by name"`
+	CreateHostedZone bool   `yaml:"create_hosted_zone,omitempty" desc:"Create the hosted zone when Route53 has none for the domain, and print the NS records to delegate the domain to"`
+	CertificateARN   string `yaml:"certificate_arn,omitempty" desc:"ARN of an existing ACM certificate covering the domains of the services, instead of creating one"`
+}
+
+// The settings of a root domain, empty when it has none
+func (c *ProjectConfig) Domain(rootDomain string) DomainConfig {
+	for _, domain := range c.Domains {
+		if domain.Name == rootDomain {
+			return domain
+		}
+	}
+	return DomainConfig{Name: rootDomain}
+}
+
+// The VPC created by the bootstrap stack, or an existing VPC given by id and subnets or found by lookup
+type VPCConfig struct {
+	CIDR              string           `yaml:"cidr,omitempty" desc:"CIDR block of the created VPC, defaults to 10.0.0.0/16"`
+	AZs               int              `yaml:"azs,omitempty" desc:"Number of availability zones, each with a private and a public subnet. Defaults to 2."`
+	PrivateSubnetSize int              `yaml:"private_subnet_size,omitempty" desc:"Prefix length of the private subnets, defaults to 24"`
+	PublicSubnetSize  int              `yaml:"public_subnet_size,omitempty" desc:"Prefix length of the public subnets, defaults to 24"`
+	ID                string           `yaml:"id,omitempty" desc:"ID of an existing VPC to use instead of creating one, with its private_subnets and public_subnets"`
+	PrivateSubnets    []string         `yaml:"private_subnets,omitempty" desc:"IDs of the existing subnets tasks and internal load balancers run in"`
+	PublicSubnets     []string         `yaml:"public_subnets,omitempty" desc:"IDs of the existing subnets internet-facing load balancers run in"`
+	Lookup            *VPCLookupConfig `yaml:"lookup,omitempty" desc:"Find an existing VPC and its subnets by tags instead of creating one"`
+	Egress            string           `yaml:"egress,omitempty" enum:"endpoints,nat-gateway,single-nat-gateway,nat-instance,public-ip" desc:"How tasks reach the internet: not at all with VPC endpoints to AWS only (the default), a NAT gateway per availability zone, a single NAT gateway, a NAT instance, or public IPs in the public subnets. Run autodock plan to compare their cost."`
+	NATInstanceType   string           `yaml:"nat_instance_type,omitempty" desc:"EC2 instance type of the NAT instance, defaults to t4g.nano"`
+	IPv6              bool             `yaml:"ipv6,omitempty" desc:"Serve internet-facing services over IPv6 too, with dualstack load balancers and AAAA records. A created VPC gets an IPv6 block in its public subnets, the public subnets of an existing VPC must have one."`
+}
+
+// Egress modes of the tasks, the first one being the default
+var EgressModes = []string{"endpoints", "nat-gateway", "single-nat-gateway", "nat-instance", "public-ip"}
+
+// The egress mode of the tasks, endpoints when not set. A nil config gives the default.
+func (c *VPCConfig) EgressMode() string {
+	if c == nil || c.Egress == "" {
+		return EgressModes[0]
+	}
+	return c.Egress
+}
+
+// The instance type of the NAT instance, t4g.nano when not set
+func (c *VPCConfig) NATInstance() string {
+	if c == nil || c.NATInstanceType == "" {
+		return "t4g.nano"
+	}
+	return c.NATInstanceType
+}
+
+type VPCLookupConfig struct {
+	VPC            map[string]string `yaml:"vpc" desc:"Tags of the VPC, e.g. {Name: corp}"`
+	PrivateSubnets map[string]string `yaml:"private_subnets" desc:"Tags of the private subnets"`
+	PublicSubnets  map[string]string `yaml:"public_subnets" desc:"Tags of the public subnets"`
+}
+
+// A resource created in the resources stack of the project
+type ResourceConfig struct {
+	Type         string   `yaml:"type" enum:"s3,sqs,sns,dynamodb" desc:"Kind of resource, named like the LocalStack service emulating it"`
+	Name         string   `yaml:"name" desc:"Name of the bucket, queue, topic or table, the same as in LocalStack. Queue and topic names ending in .fifo are FIFO."`
+	PartitionKey string   `yaml:"partition_key,omitempty" desc:"Partition key of a DynamoDB table, as name or name:type with a type of S, N or B. Defaults to id:S."`
+	SortKey      string   `yaml:"sort_key,omitempty" desc:"Sort key of a DynamoDB table, as name or name:type"`
+	Services     []string `yaml:"services,omitempty" desc:"Services granted read-write access. Defaults to the services depending on the LocalStack service, or to every deployed service when there is none."`
+}
+
+// Settings from the x-autodock block of a service
+type ServiceConfig struct {
+	Domain      OneOrMany[DomainEntry]   `yaml:"domain,omitempty" scope:"service" desc:"Domain names the service is served on, e.g. api.example.com, or a list of names and redirects"`
+	Path        string                   `yaml:"path,omitempty" scope:"service" desc:"Only route requests under this URL path to the service, e.g. /api"`
+	Scaling     *ScalingConfig           `yaml:"scaling,omitempty" scope:"service" desc:"Autoscaling of the number of tasks"`
+	Size        *SizeConfig              `yaml:"size,omitempty" desc:"CPU and memory of each task"`
+	HealthCheck *HealthCheckConfig       `yaml:"health_check,omitempty" scope:"service" desc:"Load balancer health check"`
+	IAM         *IAMConfig               `yaml:"iam,omitempty" desc:"Permissions granted to the containers through the task role"`
+	Visibility  string                   `yaml:"visibility,omitempty" scope:"service" enum:"public,internal" desc:"public for an internet-facing load balancer, internal to only serve requests from inside the VPC"`
+	CDN         *CDNConfig               `yaml:"cdn,omitempty" scope:"service" desc:"Serve the service through a CloudFront distribution"`
+	WAF         *WAFConfig               `yaml:"waf,omitempty" scope:"service" desc:"Filter the requests to the load balancer with an AWS WAF web ACL"`
+	AllowCIDRs  []string                 `yaml:"allow_cidrs,omitempty" scope:"service" desc:"Address ranges allowed to reach the load balancer, e.g. the office VPN. Defaults to anywhere for a public service, and to the VPC range for an internal one."`
+	Exec        bool                     `yaml:"exec,omitempty" scope:"service" desc:"Allow opening a shell in the running containers with autodock exec (ECS Exec)"`
+	Deployment  *DeploymentConfig        `yaml:"deployment,omitempty" scope:"service" desc:"How new task definitions replace the running tasks"`
+	Smoke       []SmokeCheckConfig       `yaml:"smoke,omitempty" scope:"service" desc:"HTTP checks of https://<domain> once the service is deployed. A failing check fails the deploy."`
+	Schedule    string                   `yaml:"schedule,omitempty" desc:"Run the service as a job on this schedule instead of as a long-running service, e.g. cron(0 3 * * ? *) or rate(1 hour)"`
+	Timezone    string                   `yaml:"timezone,omitempty" desc:"Time zone of a cron schedule, e.g. Europe/Paris. Defaults to UTC."`
+	Capacity    []CapacityProviderConfig `yaml:"capacity_providers,omitempty" desc:"Capacity provider strategy placing the tasks on Fargate and Fargate Spot, instead of only on Fargate"`
+	Task        string                   `yaml:"task,omitempty" desc:"Run the service in one task with the services of the same task, sharing localhost. The one with a domain or a schedule runs the task and holds its settings, the others are its sidecars."`
+	Essential   *bool                    `yaml:"essential,omitempty" desc:"Whether the task stops when this sidecar stops. Defaults to true, unless a container of the task waits for it to complete."`
+}
+
+// The domain name the service is primarily served on, its first name that isn't redirected. Empty when it has none.
+func (c *ServiceConfig) PrimaryDomain() string {
+	for _, entry := range c.Domain {
+		if entry.Redirect == "" {
+			return entry.Name
+		}
+	}
+	return ""
+}
+
+// Every domain name of the service, served or redirected, in order
+func (c *ServiceConfig) DomainNames() []string {
+	names := []string{}
+	for _, entry := range c.Domain {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+// The domain names redirected to another one
+func (c *ServiceConfig) Redirects() []DomainEntry {
+	redirects := []DomainEntry{}
+	for _, entry := range c.Domain {
+		if entry.Redirect != "" {
+			redirects = append(redirects, entry)
+		}
+	}
+	return redirects
+}
+
+// Read the legacy x-domain-name extension, a domain name or a list of them. Setting domain too is an error, as
+// one of them would be ignored.
+func (c *ServiceConfig) applyLegacyDomain(service *types.ServiceConfig) error {
+	if _, ok := service.Extensions["x-domain-name"]; !ok {
+		return nil
+	}
+	if len(c.Domain) > 0 {
+		return fmt.Errorf("x-domain-name and %s.domain are both set, remove x-domain-name", ExtensionKey)
+	}
+	switch legacyDomain := service.Extensions["x-domain-name"].(type) {
+	case string:
+		c.Domain = OneOrMany[DomainEntry]{{Name: legacyDomain}}
+	case []any:
+		for _, name := range legacyDomain {
+			if name, ok := name.(string); ok {
+				c.Domain = append(c.Domain, DomainEntry{Name: name})
+			}
+		}
+	}
+	return nil
+}
+
+type ScalingConfig struct {
+	Min               *int `yaml:"min,omitempty" desc:"Minimum number of tasks, defaults to deploy.replicas or 1. 0 lets the service scale in to no tasks."`
+	Max               int  `yaml:"max,omitempty" desc:"Maximum number of tasks"`
+	TargetCPU         int  `yaml:"target_cpu,omitempty" desc:"Target average CPU utilization, in percent"`
+	TargetMemory      int  `yaml:"target_memory,omitempty" desc:"Target average memory utilization, in percent"`
+	RequestsPerTarget int  `yaml:"requests_per_target,omitempty" desc:"Target number of load balancer requests per task per minute"`
+}
+
+type SizeConfig struct {
+	CPU    int `yaml:"cpu,omitempty" desc:"CPU units, 1024 is one vCPU"`
+	Memory int `yaml:"memory,omitempty" desc:"Memory in MiB"`
+}
+
+type HealthCheckConfig struct {
+	Path               string `yaml:"path,omitempty" desc:"Path requested by the load balancer, defaults to /"`
+	Interval           int    `yaml:"interval,omitempty" desc:"Seconds between two health checks"`
+	Timeout            int    `yaml:"timeout,omitempty" desc:"Seconds to wait for a response"`
+	HealthyThreshold   int    `yaml:"healthy_threshold,omitempty" desc:"Consecutive successes before a task is considered healthy"`
+	UnhealthyThreshold int    `yaml:"unhealthy_threshold,omitempty" desc:"Consecutive failures before a task is considered unhealthy"`
+	StatusCodes        string `yaml:"status_codes,omitempty" desc:"HTTP codes of a healthy response, e.g. 200 or 200-399"`
+}
+
+type CDNConfig struct {
+	CachePaths []string `yaml:"cache_paths,omitempty" desc:"Path patterns of the responses cached by CloudFront, e.g. /assets/*. Other requests reach the service uncached."`
+	PriceClass string   `yaml:"price_class,omitempty" enum:"PriceClass_100,PriceClass_200,PriceClass_All" desc:"Edge locations serving the distribution, defaults to PriceClass_100 (North America and Europe)"`
+	Static     string   `yaml:"static,omitempty" desc:"Directory of static files served from an S3 bucket instead of a container, e.g. ./dist"`
+}
+
+// Fargate capacity providers: on-demand, and spare capacity that can be interrupted with a two minute warning
+var capacityProviders = []string{"FARGATE", "FARGATE_SPOT"}
+
+type CapacityProviderConfig struct {
+	Provider string `yaml:"provider" enum:"FARGATE,FARGATE_SPOT" desc:"FARGATE for on-demand tasks, FARGATE_SPOT for interruptible tasks at a discount"`
+	Base     int    `yaml:"base,omitempty" desc:"Tasks placed on this provider before the weights apply, e.g. 1 on FARGATE to keep a task that isn't interrupted"`
+	Weight   int    `yaml:"weight,omitempty" desc:"Relative share of the tasks beyond the base placed on this provider"`
+}
+
+// Deployments of an ECS service. Rolling deployments are made by ECS, which stops a deployment whose tasks keep
+// failing to start or to become healthy and rolls the service back to its previous task definition. Blue/green
+// deployments are made by CodeDeploy, which starts a new set of tasks and shifts the traffic to them.
+type DeploymentConfig struct {
+	Strategy          string   `yaml:"strategy,omitempty" enum:"rolling,blue_green" desc:"rolling to replace the tasks in place, blue_green to shift the traffic to a new set of tasks with CodeDeploy. Defaults to rolling."`
+	MinHealthyPercent *int     `yaml:"min_healthy_percent,omitempty" desc:"Share of the desired tasks kept running during a rolling deployment, in percent. Defaults to 100."`
+	MaxPercent        int      `yaml:"max_percent,omitempty" desc:"Most tasks running during a rolling deployment, old and new, in percent of the desired tasks. Defaults to 200."`
+	TrafficShifting   string   `yaml:"traffic_shifting,omitempty" enum:"CodeDeployDefault.ECSAllAtOnce,CodeDeployDefault.ECSCanary10Percent5Minutes,CodeDeployDefault.ECSCanary10Percent15Minutes,CodeDeployDefault.ECSLinear10PercentEvery1Minutes,CodeDeployDefault.ECSLinear10PercentEvery3Minutes" desc:"How CodeDeploy shifts the traffic of a blue_green deployment to the new tasks, e.g. CodeDeployDefault.ECSCanary10Percent5Minutes for 10% of the requests during 5 minutes, then all of them. Defaults to CodeDeployDefault.ECSAllAtOnce."`
+	Alarms            []string `yaml:"alarms,omitempty" desc:"Names of CloudWatch alarms that stop a blue_green deployment and shift the traffic back to the previous tasks when they go off"`
+	RollbackOnSmoke   bool     `yaml:"rollback_on_smoke_failure,omitempty" desc:"Deploy the previous task definition again when a smoke check fails"`
+}
+
+// An HTTP check of a deployed service, requested again until it passes or runs out of retries
+type SmokeCheckConfig struct {
+	Path    string `yaml:"path" desc:"Path requested on the primary domain of the service, e.g. /healthz"`
+	Status  int    `yaml:"status,omitempty" desc:"Expected HTTP status code, redirects are not followed. Defaults to 200."`
+	Body    string `yaml:"body,omitempty" desc:"Regular expression the response body must match, e.g. \"status\":\\s*\"ok\""`
+	Timeout int    `yaml:"timeout,omitempty" desc:"Seconds to wait for each response. Defaults to 10."`
+	Retries int    `yaml:"retries,omitempty" desc:"Attempts after a failed one, 5 seconds apart. Defaults to 3."`
+}
+
+// CodeDeploy configurations shifting the traffic of ECS services
+var trafficShiftings = []string{
+	"CodeDeployDefault.ECSAllAtOnce",
+	"CodeDeployDefault.ECSCanary10Percent5Minutes",
+	"CodeDeployDefault.ECSCanary10Percent15Minutes",
+	"CodeDeployDefault.ECSLinear10PercentEvery1Minutes",
+	"CodeDeployDefault.ECSLinear10PercentEvery3Minutes",
+}
+
+// Whether the service is deployed blue/green by CodeDeploy rather than by ECS
+func (c *ServiceConfig) IsBlueGreen() bool {
+	return c.Deployment != nil && c.Deployment.Strategy == "blue_green"
+}
+
+// Whether the service is a static site, served from an S3 bucket without a container
+func (c *ServiceConfig) IsStatic() bool {
+	return c.CDN != nil && c.CDN.Static != ""
+}
+
+type WAFConfig struct {
+	ManagedRules []string `yaml:"managed_rules,omitempty" desc:"AWS managed rule groups, defaults to AWSManagedRulesCommonRuleSet, AWSManagedRulesKnownBadInputsRuleSet and AWSManagedRulesAmazonIpReputationList"`
+	RateLimit    int      `yaml:"rate_limit,omitempty" desc:"Requests an IP address can make in 5 minutes before its requests are blocked"`
+	Mode         string   `yaml:"mode,omitempty" enum:"block,count" desc:"count to only count the requests the rules match while rolling them out, defaults to block"`
+}
+
+// Managed rule groups of a web ACL when none are set
+var DefaultWAFManagedRules = []string{"AWSManagedRulesCommonRuleSet", "AWSManagedRulesKnownBadInputsRuleSet", "AWSManagedRulesAmazonIpReputationList"}
+
+// The managed rule groups of the web ACL
+func (c *WAFConfig) Rules() []string {
+	if len(c.ManagedRules) == 0 {
+		return DefaultWAFManagedRules
+	}
+	return c.ManagedRules
+}
+
+type IAMConfig struct {
+	ManagedPolicies []string              `yaml:"managed_policies,omitempty" desc:"ARNs of managed policies attached to the task role"`
+	Statements      []PolicyStatement     `yaml:"statements,omitempty" desc:"Inline policy statements of the task role"`
+	S3              OneOrMany[S3Grant]    `yaml:"s3,omitempty" desc:"Access to S3 buckets, expanded to least-privilege statements"`
+	SQS             OneOrMany[QueueGrant] `yaml:"sqs,omitempty" desc:"Access to SQS queues, expanded to least-privilege statements"`
+	SNS             OneOrMany[TopicGrant] `yaml:"sns,omitempty" desc:"Permission to publish to SNS topics"`
+	DynamoDB        OneOrMany[TableGrant] `yaml:"dynamodb,omitempty" desc:"Access to DynamoDB tables and their indexes, expanded to least-privilege statements"`
+}
+
+// Access levels of the shorthand grants
+var grantAccesses = []string{"read", "write", "read-write"}
+
+type S3Grant struct {
+	Bucket string `yaml:"bucket" desc:"Name or ARN of the bucket"`
+	Prefix string `yaml:"prefix,omitempty" desc:"Only grant access to the object keys under this prefix, e.g. uploads/"`
+	Access string `yaml:"access,omitempty" enum:"read,write,read-write" desc:"Defaults to read"`
+}
+
+type QueueGrant struct {
+	Queue  string `yaml:"queue" desc:"Name or ARN of the queue"`
+	Access string `yaml:"access,omitempty" enum:"read,write,read-write" desc:"read receives and deletes messages, write sends them. Defaults to read."`
+}
+
+type TopicGrant struct {
+	Topic string `yaml:"topic" desc:"Name or ARN of the topic"`
+}
+
+type TableGrant struct {
+	Table  string `yaml:"table" desc:"Name or ARN of the table"`
+	Access string `yaml:"access,omitempty" enum:"read,write,read-write" desc:"Defaults to read"`
+}
+
+// A setting written either as a single value or as a list of values
+type OneOrMany[T any] []T
+
+func (OneOrMany[T]) oneOrMany() {}
+
+// Marks the OneOrMany types for the JSON Schema
+type oneOrMany interface{ oneOrMany() }
+
+func (o *OneOrMany[T]) UnmarshalYAML(node *yaml.Node) error {
+	items := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		items = node.Content
+	}
+	values := OneOrMany[T]{}
+	for _, item := range items {
+		var value T
+		if err := decodeNodeStrict(item, &value); err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	*o = values
+	return nil
+}
+
+// Decode a yaml.Node, rejecting unknown keys. A yaml.Node decodes without checking for them, so it is encoded and
+// decoded again strictly.
+func decodeNodeStrict(node *yaml.Node, out any) error {
+	content, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return err
+		}
+		// shift the line numbers of the re-encoded node to its position in the document
+		for i, message := range typeErr.Errors {
+			if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+				line, _ := strconv.Atoi(match[1])
+				typeErr.Errors[i] = fmt.Sprintf("line %d: %s", line+node.Line-1, match[2])
+			}
+		}
+		return typeErr
+	}
+	return nil
+}
+
+// A domain name of a service, served by it or redirected to another name
+type DomainEntry struct {
+	Name     string `yaml:"name" desc:"Domain name, e.g. www.example.com, or an apex domain such as example.com"`
+	Redirect string `yaml:"redirect,omitempty" desc:"Answer the requests to name with a 301 redirect to this domain name, keeping their path and query"`
+}
+
+func (DomainEntry) stringShorthand() {}
+
+// Marks the types that can also be written as a string, for the JSON Schema
+type stringShorthand interface{ stringShorthand() }
+
+// A domain entry is also written as its name alone
+func (e *DomainEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&e.Name)
+	}
+	type plain DomainEntry
+	return decodeNodeStrict(node, (*plain)(e))
+}
+
+type PolicyStatement struct {
+	Effect   string   `yaml:"effect,omitempty" enum:"Allow,Deny" desc:"Defaults to Allow"`
+	Action   []string `yaml:"action" desc:"Actions, e.g. s3:GetObject"`
+	Resource []string `yaml:"resource" desc:"Resource ARNs"`
+}
+
+// Parse and check the top-level x-autodock block of a project
+func ParseProjectConfig(project *types.Project) (*ProjectConfig, error) {
+	config := &ProjectConfig{}
+	if err := decodeStrict(project.Extensions[ExtensionKey], config); err != nil {
+		return nil, fmt.Errorf("invalid top-level %s: %w", ExtensionKey, err)
+	}
+	if errs := append(config.check(), config.checkAgainst(project)...); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid top-level %s: %w", ExtensionKey, joinFieldErrors(errs))
+	}
+	return config, nil
+}
+
+// Parse and check the x-autodock block of a service, filling unset fields from the project-level defaults
+func ParseServiceConfig(project *types.Project, service *types.ServiceConfig) (*ServiceConfig, error) {
+	projectConfig, err := ParseProjectConfig(project)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ServiceConfig{}
+	if err := decodeStrict(service.Extensions[ExtensionKey], config); err != nil {
+		return nil, fmt.Errorf("invalid %s in service %s: %w", ExtensionKey, service.Name, err)
+	}
+	// x-domain-name predates the x-autodock block and is still honoured
+	if err := config.applyLegacyDomain(service); err != nil {
+		return nil, fmt.Errorf("invalid service %s: %w", service.Name, err)
+	}
+	taskErrs := config.checkTask(project, service)
+	config.applyDefaults(projectConfig.Defaults)
+
+	if errs := append(append(config.check(), config.checkAgainst(service)...), taskErrs...); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid %s in service %s: %w", ExtensionKey, service.Name, joinFieldErrors(errs))
+	}
+	return config, nil
+}
+
+// Fill every unset field from the project-level defaults, except the per-service ones.
+// Fields tagged scope:"service" only apply to long-running services and are not filled for scheduled jobs.
+func (c *ServiceConfig) applyDefaults(defaults *ServiceConfig) {
+	if defaults == nil {
+		return
+	}
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(defaults).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		field, _, _ := strings.Cut(dst.Type().Field(i).Tag.Get("yaml"), ",")
+		if slices.Contains(perServiceFields, field) {
+			continue
+		}
+		if c.Schedule != "" && dst.Type().Field(i).Tag.Get("scope") == "service" {
+			continue
+		}
+		if dst.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+// Decode an extension value, rejecting keys that don't exist in the target struct
+func decodeStrict(value any, out any) error {
+	if value == nil {
+		return nil
+	}
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && err != io.EOF {
+		// line numbers refer to the re-encoded value, not to the Compose file
+		messages := []string{}
+		for _, problem := range yamlProblems(err) {
+			messages = append(messages, problem.Message)
+		}
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// A problem with a single field of an x-autodock block, addressed by a dotted path such as scaling.max
+type fieldError struct {
+	path    string
+	message string
+}
+
+func (e fieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.path, e.message)
+}
+
+func joinFieldErrors(errs []fieldError) error {
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// Fields of the x-autodock block of a service that the defaults can't set. Defaults never set them, so that a service
+// is a job only when it has a schedule of its own.
+var perServiceFields = []string{"domain", "path", "task", "essential", "schedule", "timezone"}
+
+func (c *ProjectConfig) check() []fieldError {
+	errs := []fieldError{}
+	if c.Defaults != nil {
+		if len(c.Defaults.Domain) > 0 {
+			errs = append(errs, fieldError{"defaults.domain", "can only be set per service"})
+		}
+		if c.Defaults.Path != "" {
+			errs = append(errs, fieldError{"defaults.path", "can only be set per service"})
+		}
+		if c.Defaults.Task != "" {
+			errs = append(errs, fieldError{"defaults.task", "can only be set per service"})
+		}
+		if c.Defaults.Essential != nil {
+			errs = append(errs, fieldError{"defaults.essential", "can only be set per service"})
+		}
+		if c.Defaults.Schedule != "" {
+			errs = append(errs, fieldError{"defaults.schedule", "can only be set per service"})
+		}
+		if c.Defaults.Timezone != "" {
+			errs = append(errs, fieldError{"defaults.timezone", "can only be set per service"})
+		}
+		for _, err := range c.Defaults.check() {
+			errs = append(errs, fieldError{"defaults." + err.path, err.message})
+		}
+	}
+	if vpc := c.VPC; vpc != nil {
+		errs = append(errs, vpc.check()...)
+	}
+	domains := map[string]bool{}
+	for i, domain := range c.Domains {
+		path := fmt.Sprintf("domains.%d", i)
+		if domain.Name == "" {
+			errs = append(errs, fieldError{path + ".name", "is required"})
+		} else if domains[domain.Name] {
+			errs = append(errs, fieldError{path + ".name", fmt.Sprintf("%s is declared twice", domain.Name)})
+		}
+		domains[domain.Name] = true
+		if domain.HostedZoneID != "" && domain.CreateHostedZone {
+			errs = append(errs, fieldError{path + ".create_hosted_zone", "can't be set with hosted_zone_id"})
+		}
+		if domain.HostedZoneID != "" && !strings.HasPrefix(domain.HostedZoneID, "Z") {
+			errs = append(errs, fieldError{path + ".hosted_zone_id", fmt.Sprintf("must be a Route53 hosted zone ID such as Z0123456789ABCDEFGHIJ, got %q", domain.HostedZoneID)})
+		}
+		if domain.CertificateARN != "" && (!strings.HasPrefix(domain.CertificateARN, "arn:") || !strings.Contains(domain.CertificateARN, ":acm:")) {
+			errs = append(errs, fieldError{path + ".certificate_arn", fmt.Sprintf("must be the ARN of an ACM certificate, got %q", domain.CertificateARN)})
+		}
+	}
+	seen := map[string]bool{}
+	for i, resource := range c.Resources {
+		path := fmt.Sprintf("resources.%d", i)
+		if !slices.Contains(resourceTypes, resource.Type) {
+			errs = append(errs, fieldError{path + ".type", fmt.Sprintf("must be one of %s, got %q", strings.Join(resourceTypes, ", "), resource.Type)})
+		}
+		if resource.Name == "" {
+			errs = append(errs, fieldError{path + ".name", "is required"})
+		}
+		if seen[resource.Type+"/"+resource.Name] {
+			errs = append(errs, fieldError{path + ".name", fmt.Sprintf("%s %s is declared twice", resource.Type, resource.Name)})
+		}
+		seen[resource.Type+"/"+resource.Name] = true
+		keys := []struct{ field, value string }{{"partition_key", resource.PartitionKey}, {"sort_key", resource.SortKey}}
+		for _, key := range keys {
+			if key.value != "" && resource.Type != "dynamodb" {
+				errs = append(errs, fieldError{path + "." + key.field, "only applies to dynamodb tables"})
+			} else if key.value != "" && !keyPattern.MatchString(key.value) {
+				errs = append(errs, fieldError{path + "." + key.field, fmt.Sprintf("must be name or name:type with a type of S, N or B, got %q", key.value)})
+			}
+		}
+	}
+	return errs
+}
+
+func (c *VPCConfig) check() []fieldError {
+	errs := []fieldError{}
+	existing := c.ID != "" || len(c.PrivateSubnets) > 0 || len(c.PublicSubnets) > 0
+	created := c.CIDR != "" || c.AZs != 0 || c.PrivateSubnetSize != 0 || c.PublicSubnetSize != 0
+	if existing && c.Lookup != nil {
+		errs = append(errs, fieldError{"vpc.lookup", "can't be set with id, private_subnets and public_subnets"})
+	}
+	if (existing || c.Lookup != nil) && created {
+		errs = append(errs, fieldError{"vpc", "cidr, azs and subnet sizes only apply to a created VPC, not to an existing one"})
+	}
+	if existing {
+		if c.ID == "" {
+			errs = append(errs, fieldError{"vpc.id", "is required with private_subnets and public_subnets"})
+		}
+		// load balancers need subnets in two availability zones
+		if len(c.PrivateSubnets) < 2 {
+			errs = append(errs, fieldError{"vpc.private_subnets", "needs at least 2 subnets"})
+		}
+		if len(c.PublicSubnets) < 2 {
+			errs = append(errs, fieldError{"vpc.public_subnets", "needs at least 2 subnets"})
+		}
+	}
+	if lookup := c.Lookup; lookup != nil {
+		if len(lookup.VPC) == 0 {
+			errs = append(errs, fieldError{"vpc.lookup.vpc", "needs at least one tag"})
+		}
+		if len(lookup.PrivateSubnets) == 0 {
+			errs = append(errs, fieldError{"vpc.lookup.private_subnets", "needs at least one tag"})
+		}
+		if len(lookup.PublicSubnets) == 0 {
+			errs = append(errs, fieldError{"vpc.lookup.public_subnets", "needs at least one tag"})
+		}
+	}
+
+	if c.Egress != "" && !slices.Contains(EgressModes, c.Egress) {
+		errs = append(errs, fieldError{"vpc.egress", fmt.Sprintf("must be one of %s, got %q", strings.Join(EgressModes, ", "), c.Egress)})
+	}
+	// an existing VPC keeps its own routing, only the subnets of the tasks can change
+	if (existing || c.Lookup != nil) && c.Egress != "" && c.Egress != "endpoints" && c.Egress != "public-ip" {
+		errs = append(errs, fieldError{"vpc.egress", fmt.Sprintf("%s only applies to a created VPC, an existing one routes its private subnets itself", c.Egress)})
+	}
+	if c.NATInstanceType != "" && c.EgressMode() != "nat-instance" {
+		errs = append(errs, fieldError{"vpc.nat_instance_type", "only applies to the nat-instance egress"})
+	}
+
+	vpcSize := 16
+	if c.CIDR != "" {
+		_, network, err := net.ParseCIDR(c.CIDR)
+		if err != nil || network.IP.To4() == nil || network.String() != c.CIDR {
+			errs = append(errs, fieldError{"vpc.cidr", fmt.Sprintf("must be an IPv4 network such as 10.0.0.0/16, got %q", c.CIDR)})
+		} else if vpcSize, _ = network.Mask.Size(); vpcSize < 16 || vpcSize > 24 {
+			errs = append(errs, fieldError{"vpc.cidr", "must be between a /16 and a /24"})
+		}
+	}
+	if c.AZs != 0 && (c.AZs < 2 || c.AZs > 6) {
+		errs = append(errs, fieldError{"vpc.azs", "must be between 2 and 6"})
+	}
+	sizes := []struct {
+		field string
+		size  int
+	}{{"vpc.private_subnet_size", c.PrivateSubnetSize}, {"vpc.public_subnet_size", c.PublicSubnetSize}}
+	for _, size := range sizes {
+		if size.size != 0 && (size.size <= vpcSize || size.size > 28) {
+			errs = append(errs, fieldError{size.field, fmt.Sprintf("must be a prefix length between %d and 28", vpcSize+1)})
+		}
+	}
+	if len(errs) == 0 && !existing && c.Lookup == nil {
+		if _, _, err := c.SubnetCIDRs(); err != nil {
+			errs = append(errs, fieldError{"vpc", err.Error()})
+		}
+	}
+	return errs
+}
+
+// Split the CIDR block of a created VPC into a private subnet per availability zone, followed by a public subnet per
+// availability zone. A nil config gives the default layout.
+func (c *VPCConfig) SubnetCIDRs() ([]string, []string, error) {
+	config := VPCConfig{}
+	if c != nil {
+		config = *c
+	}
+	cidr, azs, privateSize, publicSize := "10.0.0.0/16", 2, 24, 24
+	if config.CIDR != "" {
+		cidr = config.CIDR
+	}
+	if config.AZs != 0 {
+		azs = config.AZs
+	}
+	if config.PrivateSubnetSize != 0 {
+		privateSize = config.PrivateSubnetSize
+	}
+	if config.PublicSubnetSize != 0 {
+		publicSize = config.PublicSubnetSize
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, nil, err
+	}
+	vpcSize, _ := network.Mask.Size()
+	start := uint64(binary.BigEndian.Uint32(network.IP.To4()))
+	end := start + 1<<(32-vpcSize)
+	next := start
+	if config.CIDR == "" {
+		// the default VPC leaves its first block unused, as VPCs created before the layout was configurable did
+		next += 1 << (32 - privateSize)
+	}
+	allocate := func(size int) string {
+		block := uint64(1) << (32 - size)
+		next = (next + block - 1) / block * block
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(next))
+		next += block
+		return fmt.Sprintf("%s/%d", ip, size)
+	}
+	private, public := []string{}, []string{}
+	for i := 0; i < azs; i++ {
+		private = append(private, allocate(privateSize))
+	}
+	for i := 0; i < azs; i++ {
+		public = append(public, allocate(publicSize))
+	}
+	if next > end {
+		return nil, nil, fmt.Errorf("%d private /%d and %d public /%d subnets don't fit in %s", azs, privateSize, azs, publicSize, cidr)
+	}
+	return private, public, nil
+}
+
+// Check the project-level settings that refer to services
+func (c *ProjectConfig) checkAgainst(project *types.Project) []fieldError {
+	errs := []fieldError{}
+	for i, resource := range c.Resources {
+		for j, name := range resource.Services {
+			if _, ok := project.Services[name]; !ok {
+				errs = append(errs, fieldError{fmt.Sprintf("resources.%d.services.%d", i, j), fmt.Sprintf("service %s doesn't exist", name)})
+			}
+		}
+	}
+	return errs
+}
+
+var domainLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+var schedulePattern = regexp.MustCompile(`^(cron|rate|at)\(.+\)$`)
+
+// CloudFront limits: names of a distribution sharing a certificate, and cache behaviours
+const maxCDNNames = 10
+const maxCDNCachePaths = 25
+
+// CodeDeploy limit of the alarms of a deployment group
+const maxDeploymentAlarms = 10
+
+var wafManagedRulePattern = regexp.MustCompile(`^AWSManagedRules[A-Za-z0-9]+$`)
+
+// Fargate memory sizes (MiB) allowed for each CPU size
+var fargateMemory = map[int]func(memory int) bool{
+	256:   func(m int) bool { return m == 512 || m == 1024 || m == 2048 },
+	512:   func(m int) bool { return m >= 1024 && m <= 4096 && m%1024 == 0 },
+	1024:  func(m int) bool { return m >= 2048 && m <= 8192 && m%1024 == 0 },
+	2048:  func(m int) bool { return m >= 4096 && m <= 16384 && m%1024 == 0 },
+	4096:  func(m int) bool { return m >= 8192 && m <= 30720 && m%1024 == 0 },
+	8192:  func(m int) bool { return m >= 16384 && m <= 61440 && m%4096 == 0 },
+	16384: func(m int) bool { return m >= 32768 && m <= 122880 && m%8192 == 0 },
+}
+
+// The problem with a domain name, empty when it is valid
+func checkDomainName(name string) string {
+	labels := strings.Split(name, ".")
+	valid := len(labels) >= 2
+	for _, label := range labels {
+		valid = valid && domainLabelPattern.MatchString(label)
+	}
+	if !valid {
+		return fmt.Sprintf("%q is not a valid lowercase domain name", name)
+	}
+	if utils.GetRootDomain(name) == "" {
+		return fmt.Sprintf("%q is a public suffix, not a domain that can be registered", name)
+	}
+	return ""
+}
+
+func (c *ServiceConfig) check() []fieldError {
+	errs := []fieldError{}
+
+	names := map[string]bool{}
+	for i, entry := range c.Domain {
+		path := fmt.Sprintf("domain.%d", i)
+		if message := checkDomainName(entry.Name); message != "" {
+			errs = append(errs, fieldError{path + ".name", message})
+		} else if names[entry.Name] {
+			errs = append(errs, fieldError{path + ".name", fmt.Sprintf("%s is listed twice", entry.Name)})
+		}
+		names[entry.Name] = true
+		if entry.Redirect == "" {
+			continue
+		}
+		if message := checkDomainName(entry.Redirect); message != "" {
+			errs = append(errs, fieldError{path + ".redirect", message})
+		} else if entry.Redirect == entry.Name {
+			errs = append(errs, fieldError{path + ".redirect", "can't redirect a domain name to itself"})
+		}
+	}
+	if len(c.Domain) > 0 && c.PrimaryDomain() == "" {
+		errs = append(errs, fieldError{"domain", "needs a domain name that isn't redirected"})
+	}
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		errs = append(errs, fieldError{"path", "must start with /"})
+	}
+	if c.Visibility != "" && c.Visibility != "public" && c.Visibility != "internal" {
+		errs = append(errs, fieldError{"visibility", fmt.Sprintf("must be public or internal, got %q", c.Visibility)})
+	}
+	if c.Visibility == "internal" {
+		for i, entry := range c.Domain {
+			if entry.Name == utils.GetRootDomain(entry.Name) {
+				errs = append(errs, fieldError{fmt.Sprintf("domain.%d.name", i), fmt.Sprintf("an internal service can't be served on the root domain %s, its private hosted zone would hide every public name of the domain from the VPC", entry.Name)})
+			}
+		}
+	}
+	for i, cidr := range c.AllowCIDRs {
+		path := fmt.Sprintf("allow_cidrs.%d", i)
+		if _, ipNet, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fieldError{path, fmt.Sprintf("%q is not an IPv4 or IPv6 CIDR block", cidr)})
+		} else if ipNet.String() != cidr {
+			errs = append(errs, fieldError{path, fmt.Sprintf("%q has host bits set, use %s", cidr, ipNet)})
+		}
+	}
+
+	if c.Schedule != "" {
+		if !schedulePattern.MatchString(c.Schedule) {
+			errs = append(errs, fieldError{"schedule", fmt.Sprintf("%q is not a cron(...), rate(...) or at(...) expression", c.Schedule)})
+		}
+		value := reflect.ValueOf(c).Elem()
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.Tag.Get("scope") == "service" && !value.Field(i).IsZero() {
+				name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+				errs = append(errs, fieldError{name, "isn't used by scheduled jobs"})
+			}
+		}
+	} else if c.Timezone != "" {
+		errs = append(errs, fieldError{"timezone", "is only used with a schedule"})
+	}
+
+	if s := c.Scaling; s != nil {
+		if s.Min != nil && *s.Min < 0 {
+			errs = append(errs, fieldError{"scaling.min", "must not be negative"})
+		}
+		if s.Max < 1 || (s.Min != nil && s.Max < *s.Min) {
+			errs = append(errs, fieldError{"scaling.max", "must be at least 1 and not less than min"})
+		}
+		if s.TargetCPU == 0 && s.TargetMemory == 0 && s.RequestsPerTarget == 0 {
+			errs = append(errs, fieldError{"scaling", "needs at least one of target_cpu, target_memory or requests_per_target"})
+		}
+		if s.TargetCPU < 0 || s.TargetCPU > 100 {
+			errs = append(errs, fieldError{"scaling.target_cpu", "must be a percentage between 1 and 100"})
+		}
+		if s.TargetMemory < 0 || s.TargetMemory > 100 {
+			errs = append(errs, fieldError{"scaling.target_memory", "must be a percentage between 1 and 100"})
+		}
+		if s.RequestsPerTarget < 0 {
+			errs = append(errs, fieldError{"scaling.requests_per_target", "must not be negative"})
+		}
+	}
+
+	if s := c.Size; s != nil {
+		if allowed, ok := fargateMemory[s.CPU]; !ok {
+			errs = append(errs, fieldError{"size.cpu", fmt.Sprintf("%d is not a Fargate CPU size (256, 512, 1024, 2048, 4096, 8192 or 16384)", s.CPU)})
+		} else if !allowed(s.Memory) {
+			errs = append(errs, fieldError{"size.memory", fmt.Sprintf("%d MiB is not available with %d CPU units on Fargate", s.Memory, s.CPU)})
+		}
+	}
+
+	if h := c.HealthCheck; h != nil {
+		if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+			errs = append(errs, fieldError{"health_check.path", "must start with /"})
+		}
+		if h.Interval != 0 && (h.Interval < 5 || h.Interval > 300) {
+			errs = append(errs, fieldError{"health_check.interval", "must be between 5 and 300 seconds"})
+		}
+		if h.Timeout != 0 && (h.Timeout < 2 || h.Timeout > 120) {
+			errs = append(errs, fieldError{"health_check.timeout", "must be between 2 and 120 seconds"})
+		}
+		if h.Timeout != 0 && h.Interval != 0 && h.Timeout >= h.Interval {
+			errs = append(errs, fieldError{"health_check.timeout", "must be shorter than the interval"})
+		}
+		if h.HealthyThreshold != 0 && (h.HealthyThreshold < 2 || h.HealthyThreshold > 10) {
+			errs = append(errs, fieldError{"health_check.healthy_threshold", "must be between 2 and 10"})
+		}
+		if h.UnhealthyThreshold != 0 && (h.UnhealthyThreshold < 2 || h.UnhealthyThreshold > 10) {
+			errs = append(errs, fieldError{"health_check.unhealthy_threshold", "must be between 2 and 10"})
+		}
+	}
+
+	if cdn := c.CDN; cdn != nil {
+		if len(c.Domain) == 0 {
+			errs = append(errs, fieldError{"cdn", "needs a domain to serve the distribution on"})
+		}
+		if len(c.Domain) > maxCDNNames {
+			errs = append(errs, fieldError{"domain", fmt.Sprintf("can't have more than %d names with a cdn, they share its certificate", maxCDNNames)})
+		}
+		if c.Visibility == "internal" {
+			errs = append(errs, fieldError{"cdn", "can't serve an internal service, CloudFront reaches it from the internet"})
+		}
+		if len(cdn.CachePaths) > maxCDNCachePaths {
+			errs = append(errs, fieldError{"cdn.cache_paths", fmt.Sprintf("can't have more than %d patterns", maxCDNCachePaths)})
+		}
+		for i, pattern := range cdn.CachePaths {
+			if pattern == "" || len(pattern) > 255 {
+				errs = append(errs, fieldError{fmt.Sprintf("cdn.cache_paths.%d", i), "must be a path pattern of 1 to 255 characters, e.g. /assets/*"})
+			}
+		}
+		if cdn.PriceClass != "" && !slices.Contains([]string{"PriceClass_100", "PriceClass_200", "PriceClass_All"}, cdn.PriceClass) {
+			errs = append(errs, fieldError{"cdn.price_class", fmt.Sprintf("must be PriceClass_100, PriceClass_200 or PriceClass_All, got %q", cdn.PriceClass)})
+		}
+		if cdn.Static != "" {
+			// static sites are only files, settings of the container and the load balancer don't apply
+			unused := []struct {
+				name string
+				set  bool
+			}{
+				{"path", c.Path != ""},
+				{"scaling", c.Scaling != nil},
+				{"health_check", c.HealthCheck != nil},
+				{"exec", c.Exec},
+				{"waf", c.WAF != nil},
+				{"allow_cidrs", len(c.AllowCIDRs) > 0},
+				{"cdn.cache_paths", len(cdn.CachePaths) > 0},
+			}
+			for _, field := range unused {
+				if field.set {
+					errs = append(errs, fieldError{field.name, "isn't used by a static site"})
+				}
+			}
+			if len(c.Redirects()) > 0 {
+				errs = append(errs, fieldError{"domain", "can't redirect names of a static site"})
+			}
+		}
+	}
+
+	if w := c.WAF; w != nil {
+		for i, name := range w.ManagedRules {
+			path := fmt.Sprintf("waf.managed_rules.%d", i)
+			switch {
+			case !wafManagedRulePattern.MatchString(name):
+				errs = append(errs, fieldError{path, fmt.Sprintf("%q is not the name of an AWS managed rule group, e.g. AWSManagedRulesSQLiRuleSet", name)})
+			case name == "AWSManagedRulesATPRuleSet" || name == "AWSManagedRulesACFPRuleSet":
+				errs = append(errs, fieldError{path, fmt.Sprintf("%s needs a configuration of the login or sign-up pages that autodock doesn't generate", name)})
+			case slices.Contains(w.ManagedRules[:i], name):
+				errs = append(errs, fieldError{path, fmt.Sprintf("%s is listed twice", name)})
+			}
+		}
+		if w.RateLimit != 0 && (w.RateLimit < 10 || w.RateLimit > 2000000000) {
+			errs = append(errs, fieldError{"waf.rate_limit", "must be between 10 and 2000000000 requests"})
+		}
+		if w.Mode != "" && w.Mode != "block" && w.Mode != "count" {
+			errs = append(errs, fieldError{"waf.mode", fmt.Sprintf("must be block or count, got %q", w.Mode)})
+		}
+	}
+
+	bases, weights := 0, 0
+	for i, provider := range c.Capacity {
+		path := fmt.Sprintf("capacity_providers.%d", i)
+		if !slices.Contains(capacityProviders, provider.Provider) {
+			errs = append(errs, fieldError{path + ".provider", fmt.Sprintf("must be FARGATE or FARGATE_SPOT, got %q", provider.Provider)})
+		} else if slices.ContainsFunc(c.Capacity[:i], func(other CapacityProviderConfig) bool { return other.Provider == provider.Provider }) {
+			errs = append(errs, fieldError{path + ".provider", fmt.Sprintf("%s is listed twice", provider.Provider)})
+		}
+		if provider.Base < 0 || provider.Base > 100000 {
+			errs = append(errs, fieldError{path + ".base", "must be between 0 and 100000"})
+		}
+		if provider.Weight < 0 || provider.Weight > 1000 {
+			errs = append(errs, fieldError{path + ".weight", "must be between 0 and 1000"})
+		}
+		if provider.Base > 0 {
+			bases++
+		}
+		weights += max(provider.Weight, 0)
+	}
+	if bases > 1 {
+		errs = append(errs, fieldError{"capacity_providers", "only one provider can have a base"})
+	}
+	if len(c.Capacity) > 0 && weights == 0 {
+		errs = append(errs, fieldError{"capacity_providers", "needs a provider with a weight, to place the tasks beyond the base"})
+	}
+
+	if d := c.Deployment; d != nil {
+		if d.Strategy != "" && d.Strategy != "rolling" && d.Strategy != "blue_green" {
+			errs = append(errs, fieldError{"deployment.strategy", fmt.Sprintf("must be rolling or blue_green, got %q", d.Strategy)})
+		}
+		if d.MinHealthyPercent != nil && (*d.MinHealthyPercent < 0 || *d.MinHealthyPercent > 100) {
+			errs = append(errs, fieldError{"deployment.min_healthy_percent", "must be a percentage between 0 and 100"})
+		}
+		if d.MaxPercent != 0 && (d.MaxPercent < 100 || d.MaxPercent > 200) {
+			errs = append(errs, fieldError{"deployment.max_percent", "must be a percentage between 100 and 200"})
+		}
+		if d.MaxPercent == 100 && (d.MinHealthyPercent == nil || *d.MinHealthyPercent == 100) {
+			errs = append(errs, fieldError{"deployment", "needs room to replace the tasks, a max_percent above 100 or a min_healthy_percent below 100"})
+		}
+		if d.TrafficShifting != "" && !slices.Contains(trafficShiftings, d.TrafficShifting) {
+			errs = append(errs, fieldError{"deployment.traffic_shifting", fmt.Sprintf("must be one of %s, got %q", strings.Join(trafficShiftings, ", "), d.TrafficShifting)})
+		}
+		if len(d.Alarms) > maxDeploymentAlarms {
+			errs = append(errs, fieldError{"deployment.alarms", fmt.Sprintf("can't have more than %d alarms", maxDeploymentAlarms)})
+		}
+		for i, alarm := range d.Alarms {
+			if alarm == "" || len(alarm) > 255 {
+				errs = append(errs, fieldError{fmt.Sprintf("deployment.alarms.%d", i), "must be the name of a CloudWatch alarm, of 1 to 255 characters"})
+			}
+		}
+		// each strategy has its own settings
+		unused := []struct {
+			name      string
+			set       bool
+			blueGreen bool
+		}{
+			{"deployment.min_healthy_percent", d.MinHealthyPercent != nil, false},
+			{"deployment.max_percent", d.MaxPercent != 0, false},
+			{"deployment.traffic_shifting", d.TrafficShifting != "", true},
+			{"deployment.alarms", len(d.Alarms) > 0, true},
+		}
+		for _, field := range unused {
+			if field.set && field.blueGreen != c.IsBlueGreen() {
+				strategy := "rolling"
+				if field.blueGreen {
+					strategy = "blue_green"
+				}
+				errs = append(errs, fieldError{field.name, fmt.Sprintf("is only used by %s deployments", strategy)})
+			}
+		}
+		if d.RollbackOnSmoke && len(c.Smoke) == 0 {
+			errs = append(errs, fieldError{"deployment.rollback_on_smoke_failure", "needs smoke checks to roll back on"})
+		}
+		if d.RollbackOnSmoke && c.IsStatic() {
+			errs = append(errs, fieldError{"deployment.rollback_on_smoke_failure", "can't roll back the files of a static site"})
+		}
+		if c.IsBlueGreen() && c.Path != "" {
+			errs = append(errs, fieldError{"deployment.strategy", "can't shift the traffic of a service served under a path, CodeDeploy only switches the default action of the load balancer"})
+		}
+	}
+
+	if len(c.Smoke) > 0 {
+		if c.PrimaryDomain() == "" {
+			errs = append(errs, fieldError{"smoke", "needs a domain to request"})
+		}
+		if c.Visibility == "internal" {
+			errs = append(errs, fieldError{"smoke", "can't reach an internal service from outside the VPC"})
+		}
+	}
+	for i, check := range c.Smoke {
+		path := fmt.Sprintf("smoke.%d", i)
+		if !strings.HasPrefix(check.Path, "/") {
+			errs = append(errs, fieldError{path + ".path", "must start with /"})
+		}
+		if check.Status != 0 && (check.Status < 100 || check.Status > 599) {
+			errs = append(errs, fieldError{path + ".status", fmt.Sprintf("%d is not an HTTP status code", check.Status)})
+		}
+		if _, err := regexp.Compile(check.Body); err != nil {
+			errs = append(errs, fieldError{path + ".body", fmt.Sprintf("is not a regular expression: %s", err)})
+		}
+		if check.Timeout < 0 || check.Timeout > 120 {
+			errs = append(errs, fieldError{path + ".timeout", "must be between 1 and 120 seconds"})
+		}
+		if check.Retries < 0 || check.Retries > 60 {
+			errs = append(errs, fieldError{path + ".retries", "must be between 0 and 60"})
+		}
+	}
+
+	if iam := c.IAM; iam != nil {
+		for i, arn := range iam.ManagedPolicies {
+			if !strings.HasPrefix(arn, "arn:") {
+				errs = append(errs, fieldError{fmt.Sprintf("iam.managed_policies.%d", i), fmt.Sprintf("%q is not an ARN", arn)})
+			}
+		}
+		for i, statement := range iam.Statements {
+			path := fmt.Sprintf("iam.statements.%d", i)
+			if statement.Effect != "" && statement.Effect != "Allow" && statement.Effect != "Deny" {
+				errs = append(errs, fieldError{path + ".effect", "must be Allow or Deny"})
+			}
+			if len(statement.Action) == 0 {
+				errs = append(errs, fieldError{path + ".action", "is required"})
+			}
+			if len(statement.Resource) == 0 {
+				errs = append(errs, fieldError{path + ".resource", "is required"})
+			}
+		}
+		for i, grant := range iam.S3 {
+			path := fmt.Sprintf("iam.s3.%d", i)
+			if grant.Bucket == "" {
+				errs = append(errs, fieldError{path + ".bucket", "is required"})
+			}
+			errs = append(errs, checkAccess(path, grant.Access)...)
+		}
+		for i, grant := range iam.SQS {
+			path := fmt.Sprintf("iam.sqs.%d", i)
+			if grant.Queue == "" {
+				errs = append(errs, fieldError{path + ".queue", "is required"})
+			}
+			errs = append(errs, checkAccess(path, grant.Access)...)
+		}
+		for i, grant := range iam.SNS {
+			if grant.Topic == "" {
+				errs = append(errs, fieldError{fmt.Sprintf("iam.sns.%d.topic", i), "is required"})
+			}
+		}
+		for i, grant := range iam.DynamoDB {
+			path := fmt.Sprintf("iam.dynamodb.%d", i)
+			if grant.Table == "" {
+				errs = append(errs, fieldError{path + ".table", "is required"})
+			}
+			errs = append(errs, checkAccess(path, grant.Access)...)
+		}
+	}
+
+	return errs
+}
+
+func checkAccess(path string, access string) []fieldError {
+	if access != "" && !slices.Contains(grantAccesses, access) {
+		return []fieldError{{path + ".access", fmt.Sprintf("must be one of %s, got %q", strings.Join(grantAccesses, ", "), access)}}
+	}
+	return nil
+}
+
+// Check the settings that depend on the rest of the Compose service definition
+func (c *ServiceConfig) checkAgainst(service *types.ServiceConfig) []fieldError {
+	errs := []fieldError{}
+	if c.Scaling != nil && c.Scaling.Min == nil && service.GetScale() > c.Scaling.Max {
+		errs = append(errs, fieldError{"scaling.max", fmt.Sprintf("is lower than the %d replicas of the service", service.GetScale())})
+	}
+	return errs
+}
+
+// A problem found in the x-autodock blocks of a Compose file
+type Problem struct {
+	File    string
+	Line    int // 0 when the line isn't known
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// The parts of a Compose file that hold x-autodock blocks. Every other key ends up in the inline maps,
+// so that strict decoding only reports unknown keys inside the x-autodock blocks.
+type rawComposeFile struct {
+	Config   *ProjectConfig            `yaml:"x-autodock"`
+	Services map[string]rawServiceFile `yaml:"services"`
+	Rest     map[string]any            `yaml:",inline"`
+}
+
+type rawServiceFile struct {
+	Config *ServiceConfig `yaml:"x-autodock"`
+	Rest   map[string]any `yaml:",inline"`
+}
+
+// Check the x-autodock blocks of a Compose file and report every problem found, with its line when possible.
+// Unknown keys and wrong types are read from the file as written; value checks use the loaded project,
+// after variable interpolation.
+func Validate(composeFile string, project *types.Project) []Problem {
+	content, err := os.ReadFile(composeFile)
+	if err != nil {
+		return []Problem{{File: composeFile, Message: err.Error()}}
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return []Problem{{File: composeFile, Message: err.Error()}}
+	}
+
+	problems := []Problem{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&rawComposeFile{}); err != nil && err != io.EOF {
+		for _, problem := range yamlProblems(err) {
+			problem.File = composeFile
+			problems = append(problems, problem)
+		}
+	}
+	decodeFailed := len(problems) > 0
+
+	addFieldErrors := func(prefix []string, label string, errs []fieldError) {
+		for _, err := range errs {
+			path := append(append([]string{}, prefix...), strings.Split(err.path, ".")...)
+			problems = append(problems, Problem{
+				File:    composeFile,
+				Line:    lineOf(&root, path),
+				Message: fmt.Sprintf("%s%s: %s", label, err.path, err.message),
+			})
+		}
+	}
+
+	projectConfig := &ProjectConfig{}
+	defaultsErrs := []fieldError{}
+	if err := decodeStrict(project.Extensions[ExtensionKey], projectConfig); err != nil {
+		if !decodeFailed {
+			problems = append(problems, Problem{File: composeFile, Line: lineOf(&root, []string{ExtensionKey}), Message: err.Error()})
+		}
+	} else {
+		addFieldErrors([]string{ExtensionKey}, "", append(projectConfig.check(), projectConfig.checkAgainst(project)...))
+		if projectConfig.Defaults != nil {
+			defaultsErrs = projectConfig.Defaults.check()
+		}
+	}
+
+	for _, name := range project.ServiceNames() {
+		service := project.Services[name]
+		config := &ServiceConfig{}
+		prefix := []string{"services", name, ExtensionKey}
+		if err := decodeStrict(service.Extensions[ExtensionKey], config); err != nil {
+			if !decodeFailed {
+				problems = append(problems, Problem{File: composeFile, Line: lineOf(&root, prefix), Message: fmt.Sprintf("service %s: %s", name, err)})
+			}
+			continue
+		}
+		if err := config.applyLegacyDomain(&service); err != nil {
+			problems = append(problems, Problem{
+				File:    composeFile,
+				Line:    lineOf(&root, []string{"services", name, "x-domain-name"}),
+				Message: fmt.Sprintf("service %s: %s", name, err),
+			})
+		}
+		// the settings are checked with the defaults applied, as deployed, but the problems of the defaults
+		// themselves are only reported once, at the defaults
+		taskErrs := config.checkTask(project, &service)
+		config.applyDefaults(projectConfig.Defaults)
+		errs := []fieldError{}
+		for _, err := range append(append(config.check(), config.checkAgainst(&service)...), taskErrs...) {
+			if !slices.Contains(defaultsErrs, err) {
+				errs = append(errs, err)
+			}
+		}
+		addFieldErrors(prefix, fmt.Sprintf("service %s: ", name), errs)
+	}
+
+	return problems
+}
+
+var yamlLinePattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+var yamlUnknownFieldPattern = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+
+// Split a yaml decoding error into one problem per line
+func yamlProblems(err error) []Problem {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	problems := []Problem{}
+	for _, message := range messages {
+		problem := Problem{Message: strings.TrimPrefix(message, "yaml: ")}
+		if match := yamlLinePattern.FindStringSubmatch(problem.Message); match != nil {
+			problem.Line, _ = strconv.Atoi(match[1])
+			problem.Message = match[2]
+		}
+		if match := yamlUnknownFieldPattern.FindStringSubmatch(problem.Message); match != nil {
+			problem.Message = fmt.Sprintf("unknown key %q", match[1])
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// Line of the deepest node found along a path of mapping keys and sequence indexes.
+// Index 0 also addresses a single value written without a list, see OneOrMany.
+func lineOf(root *yaml.Node, path []string) int {
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := 0
+	for _, key := range path {
+		switch node.Kind {
+		case yaml.MappingNode:
+			var next *yaml.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					line = node.Content[i].Line
+					next = node.Content[i+1]
+					break
+				}
+			}
+			if next == nil && key == "0" {
+				continue
+			}
+			if next == nil {
+				return line
+			}
+			node = next
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(key)
+			if err != nil || index >= len(node.Content) {
+				return line
+			}
+			node = node.Content[index]
+			line = node.Line
+		default:
+			return line
+		}
+	}
+	return line
+}
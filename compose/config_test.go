@@ -0,0 +1,671 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeComposeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "docker-compose.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseServiceConfig(t *testing.T) {
+	path := writeComposeFile(t, `
+x-autodock:
+  defaults:
+    size: {cpu: 512, memory: 1024}
+    visibility: internal
+services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+      visibility: public
+  legacy:
+    image: legacy
+    x-domain-name: legacy.example.com
+  both:
+    image: both
+    x-domain-name: old.example.com
+    x-autodock:
+      domain: new.example.com
+`)
+	project := Parse(path)
+
+	api := project.Services["api"]
+	config, err := ParseServiceConfig(project, &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PrimaryDomain() != "api.example.com" || config.Visibility != "public" {
+		t.Errorf("service settings not read: %+v", config)
+	}
+	if config.Size == nil || config.Size.CPU != 512 || config.Size.Memory != 1024 {
+		t.Errorf("size not taken from defaults: %+v", config.Size)
+	}
+
+	legacy := project.Services["legacy"]
+	config, err = ParseServiceConfig(project, &legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PrimaryDomain() != "legacy.example.com" || config.Visibility != "internal" {
+		t.Errorf("legacy domain or defaults not applied: %+v", config)
+	}
+
+	both := project.Services["both"]
+	if _, err := ParseServiceConfig(project, &both); err == nil || !strings.Contains(err.Error(), "both set") {
+		t.Errorf("expected x-domain-name and domain set together to be rejected, got %v", err)
+	}
+}
+
+func TestParseServiceConfigRejectsUnknownKeys(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  api:
+    image: api
+    x-autodock:
+      domian: api.example.com
+`)
+	project := Parse(path)
+	api := project.Services["api"]
+	_, err := ParseServiceConfig(project, &api)
+	if err == nil || !strings.Contains(err.Error(), `unknown key "domian"`) {
+		t.Errorf("expected an unknown key error, got %v", err)
+	}
+}
+
+func TestIAMGrantsOneOrMany(t *testing.T) {
+	path := writeComposeFile(t, `services:
+  api:
+    image: api
+    x-autodock:
+      iam:
+        s3: {bucket: uploads, access: read-write}
+        sqs:
+          - queue: jobs
+          - queue: events
+            access: write
+        dynamodb:
+          table: users
+          acess: read
+`)
+	project := Parse(path)
+	problems := Validate(path, project)
+	expected := []string{path + `:13: unknown key "acess"`}
+	if len(problems) != len(expected) || problems[0].String() != expected[0] {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+
+	path = writeComposeFile(t, `services:
+  api:
+    image: api
+    x-autodock:
+      iam:
+        s3: {bucket: uploads, access: read-write}
+        sqs:
+          - queue: jobs
+          - queue: events
+            access: write
+`)
+	project = Parse(path)
+	api := project.Services["api"]
+	config, err := ParseServiceConfig(project, &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.IAM.S3) != 1 || config.IAM.S3[0].Bucket != "uploads" || config.IAM.S3[0].Access != "read-write" {
+		t.Errorf("single s3 grant not read: %+v", config.IAM.S3)
+	}
+	if len(config.IAM.SQS) != 2 || config.IAM.SQS[1].Queue != "events" || config.IAM.SQS[1].Access != "write" {
+		t.Errorf("sqs grants not read: %+v", config.IAM.SQS)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	path := writeComposeFile(t, `x-autodock:
+  defaults:
+    domain: example.com
+services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+      size:
+        cpu: 256
+        memory: 4096
+      scaling:
+        min: 1
+        max: 4
+        target_cpu: 50
+        cooldown: 60
+`)
+	project := Parse(path)
+	problems := Validate(path, project)
+
+	// the service block doesn't decode, so only the project-level value checks run
+	expected := []string{
+		path + `:16: unknown key "cooldown"`,
+		path + ":3: defaults.domain: can only be set per service",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+
+	// value checks run once the file decodes
+	path = writeComposeFile(t, `x-autodock:
+  defaults:
+    domain: example.com
+services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+      size:
+        cpu: 256
+        memory: 4096
+      allow_cidrs: [203.0.113.0/24, 10.0.0.1/16]
+`)
+	project = Parse(path)
+	problems = Validate(path, project)
+	expected = []string{
+		path + ":3: defaults.domain: can only be set per service",
+		path + `:12: service api: allow_cidrs.1: "10.0.0.1/16" has host bits set, use 10.0.0.0/16`,
+		path + ":11: service api: size.memory: 4096 MiB is not available with 256 CPU units on Fargate",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+
+	// an internal service gets a private hosted zone per name, which can't be the zone of its root domain
+	path = writeComposeFile(t, `services:
+  admin:
+    image: admin
+    x-autodock:
+      domain:
+        - admin.example.com
+        - example.com
+      visibility: internal
+`)
+	project = Parse(path)
+	problems = Validate(path, project)
+	expected = []string{
+		path + ":7: service admin: domain.1.name: an internal service can't be served on the root domain example.com, its private hosted zone would hide every public name of the domain from the VPC",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+
+	// a schedule in the defaults would turn every service into a job
+	path = writeComposeFile(t, `x-autodock:
+  defaults:
+    schedule: rate(1 hour)
+    timezone: Europe/Paris
+services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+`)
+	project = Parse(path)
+	problems = Validate(path, project)
+	expected = []string{
+		path + ":3: defaults.schedule: can only be set per service",
+		path + ":4: defaults.timezone: can only be set per service",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+
+	// the settings of a service are checked with the defaults applied, the problems of the defaults reported once
+	path = writeComposeFile(t, `x-autodock:
+  defaults:
+    scaling: {max: 2, target_cpu: 60}
+    size: {cpu: 256, memory: 4096}
+services:
+  api:
+    image: api
+    deploy:
+      replicas: 3
+    x-autodock:
+      domain: api.example.com
+`)
+	project = Parse(path)
+	problems = Validate(path, project)
+	expected = []string{
+		path + ":4: defaults.size.memory: 4096 MiB is not available with 256 CPU units on Fargate",
+		path + ":10: service api: scaling.max: is lower than the 3 replicas of the service",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+
+	// the web ACL only takes AWS managed rule groups that need no configuration, each once, and a rate limit AWS WAF accepts
+	path = writeComposeFile(t, `services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+      waf:
+        managed_rules:
+          - AWSManagedRulesCommonRuleSet
+          - MyRuleGroup
+          - AWSManagedRulesATPRuleSet
+          - AWSManagedRulesCommonRuleSet
+        rate_limit: 5
+        mode: log
+  web:
+    image: web
+    x-autodock:
+      domain: www.example.com
+      waf:
+        rate_limit: 3000000000
+`)
+	project = Parse(path)
+	problems = Validate(path, project)
+	expected = []string{
+		path + `:9: service api: waf.managed_rules.1: "MyRuleGroup" is not the name of an AWS managed rule group, e.g. AWSManagedRulesSQLiRuleSet`,
+		path + ":10: service api: waf.managed_rules.2: AWSManagedRulesATPRuleSet needs a configuration of the login or sign-up pages that autodock doesn't generate",
+		path + ":11: service api: waf.managed_rules.3: AWSManagedRulesCommonRuleSet is listed twice",
+		path + ":12: service api: waf.rate_limit: must be between 10 and 2000000000 requests",
+		path + `:13: service api: waf.mode: must be block or count, got "log"`,
+		path + ":19: service web: waf.rate_limit: must be between 10 and 2000000000 requests",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+}
+
+func TestJSONSchemaIsUpToDate(t *testing.T) {
+	schema, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := os.ReadFile("../x-autodock.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(schema) != string(published) {
+		t.Error("x-autodock.schema.json is out of date, regenerate it with `go run . schema > x-autodock.schema.json`")
+	}
+}
+
+func TestVPCSubnetCIDRs(t *testing.T) {
+	tests := []struct {
+		config          *VPCConfig
+		private, public []string
+	}{
+		{nil, []string{"10.0.1.0/24", "10.0.2.0/24"}, []string{"10.0.3.0/24", "10.0.4.0/24"}},
+		{
+			&VPCConfig{CIDR: "172.20.0.0/16", AZs: 3, PrivateSubnetSize: 22, PublicSubnetSize: 26},
+			[]string{"172.20.0.0/22", "172.20.4.0/22", "172.20.8.0/22"},
+			[]string{"172.20.12.0/26", "172.20.12.64/26", "172.20.12.128/26"},
+		},
+	}
+	for _, test := range tests {
+		private, public, err := test.config.SubnetCIDRs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(private, ",") != strings.Join(test.private, ",") || strings.Join(public, ",") != strings.Join(test.public, ",") {
+			t.Errorf("got %v and %v, want %v and %v", private, public, test.private, test.public)
+		}
+	}
+
+	if _, _, err := (&VPCConfig{CIDR: "10.0.0.0/24", AZs: 6, PrivateSubnetSize: 26, PublicSubnetSize: 26}).SubnetCIDRs(); err == nil {
+		t.Error("expected subnets not fitting in the VPC to be rejected")
+	}
+}
+
+func TestScalingMin(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  worker:
+    image: worker
+    deploy:
+      replicas: 3
+    x-autodock:
+      domain: worker.example.com
+      scaling: {min: 0, max: 2, target_cpu: 60}
+  api:
+    image: api
+    deploy:
+      replicas: 3
+    x-autodock:
+      domain: api.example.com
+      scaling: {max: 2, target_cpu: 60}
+`)
+	project := Parse(path)
+
+	worker := project.Services["worker"]
+	config, err := ParseServiceConfig(project, &worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Scaling.Min == nil || *config.Scaling.Min != 0 {
+		t.Errorf("Scaling.Min = %v, want an explicit 0", config.Scaling.Min)
+	}
+
+	// without a min, the replicas are the minimum, which must fit under max
+	api := project.Services["api"]
+	if _, err := ParseServiceConfig(project, &api); err == nil || !strings.Contains(err.Error(), "lower than the 3 replicas") {
+		t.Errorf("expected a max lower than the replicas to be rejected, got %v", err)
+	}
+}
+
+func TestLegacyDomainNames(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  api:
+    image: api
+    x-domain-name: [api.example.co.uk, api.eu.example.co.uk]
+  suffix:
+    image: suffix
+    x-domain-name: co.uk
+`)
+	project := Parse(path)
+
+	api := project.Services["api"]
+	config, err := ParseServiceConfig(project, &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(config.DomainNames(), ","); names != "api.example.co.uk,api.eu.example.co.uk" {
+		t.Errorf("DomainNames() = %s", names)
+	}
+
+	suffix := project.Services["suffix"]
+	if _, err := ParseServiceConfig(project, &suffix); err == nil || !strings.Contains(err.Error(), "public suffix") {
+		t.Errorf("expected a public suffix to be rejected, got %v", err)
+	}
+}
+
+func TestDomainRedirects(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  web:
+    image: web
+    x-autodock:
+      domain:
+        - name: www.example.com
+          redirect: example.com
+        - example.com
+        - example.org
+  loop:
+    image: loop
+    x-autodock:
+      domain:
+        - name: www.example.com
+          redirect: www.example.com
+`)
+	project := Parse(path)
+
+	web := project.Services["web"]
+	config, err := ParseServiceConfig(project, &web)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.PrimaryDomain() != "example.com" {
+		t.Errorf("PrimaryDomain() = %s, want the first name that isn't redirected", config.PrimaryDomain())
+	}
+	if names := strings.Join(config.DomainNames(), ","); names != "www.example.com,example.com,example.org" {
+		t.Errorf("DomainNames() = %s", names)
+	}
+	if redirects := config.Redirects(); len(redirects) != 1 || redirects[0].Name != "www.example.com" || redirects[0].Redirect != "example.com" {
+		t.Errorf("Redirects() = %+v", redirects)
+	}
+
+	loop := project.Services["loop"]
+	if _, err := ParseServiceConfig(project, &loop); err == nil {
+		t.Error("expected a domain redirecting to itself to be rejected")
+	}
+}
+
+func TestStaticSites(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  docs:
+    image: nginx
+    x-autodock:
+      domain: docs.example.com
+      cdn: {static: ./dist}
+  admin:
+    image: nginx
+    x-autodock:
+      domain: admin.example.com
+      path: /admin
+      cdn: {static: ./admin, cache_paths: [/assets/*]}
+`)
+	project := Parse(path)
+
+	docs := project.Services["docs"]
+	config, err := ParseServiceConfig(project, &docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.IsStatic() {
+		t.Errorf("docs is not a static site: %+v", config.CDN)
+	}
+
+	admin := project.Services["admin"]
+	_, err = ParseServiceConfig(project, &admin)
+	if err == nil || !strings.Contains(err.Error(), "path: isn't used by a static site") || !strings.Contains(err.Error(), "cdn.cache_paths: isn't used by a static site") {
+		t.Errorf("expected the container settings of a static site to be rejected, got %v", err)
+	}
+}
+
+func TestCapacityProviders(t *testing.T) {
+	path := writeComposeFile(t, `
+x-autodock:
+  defaults:
+    capacity_providers:
+      - {provider: FARGATE, base: 1}
+      - {provider: FARGATE_SPOT, weight: 1}
+services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+  worker:
+    image: worker
+    x-autodock:
+      domain: worker.example.com
+      capacity_providers:
+        - {provider: FARGATE_SPOT, base: 1}
+        - {provider: FARGATE_SPOT, base: 2}
+`)
+	project := Parse(path)
+
+	api := project.Services["api"]
+	config, err := ParseServiceConfig(project, &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []CapacityProviderConfig{{Provider: "FARGATE", Base: 1}, {Provider: "FARGATE_SPOT", Weight: 1}}
+	if !reflect.DeepEqual(config.Capacity, expected) {
+		t.Errorf("Capacity = %+v; want %+v", config.Capacity, expected)
+	}
+
+	worker := project.Services["worker"]
+	_, err = ParseServiceConfig(project, &worker)
+	for _, message := range []string{"FARGATE_SPOT is listed twice", "only one provider can have a base", "needs a provider with a weight"} {
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected %q, got %v", message, err)
+		}
+	}
+}
+
+func TestDeployment(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+      deployment: {min_healthy_percent: 0, max_percent: 100}
+  worker:
+    image: worker
+    x-autodock:
+      domain: worker.example.com
+      deployment: {min_healthy_percent: 150, max_percent: 100}
+`)
+	project := Parse(path)
+
+	api := project.Services["api"]
+	config, err := ParseServiceConfig(project, &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := config.Deployment; d.MinHealthyPercent == nil || *d.MinHealthyPercent != 0 || d.MaxPercent != 100 {
+		t.Errorf("Deployment = %+v; want min_healthy_percent 0 and max_percent 100", d)
+	}
+
+	worker := project.Services["worker"]
+	_, err = ParseServiceConfig(project, &worker)
+	if err == nil || !strings.Contains(err.Error(), "deployment.min_healthy_percent: must be a percentage between 0 and 100") {
+		t.Errorf("expected a min_healthy_percent error, got %v", err)
+	}
+}
+
+func TestBlueGreenDeployment(t *testing.T) {
+	path := writeComposeFile(t, `services:
+  payments:
+    image: payments
+    x-autodock:
+      domain: payments.example.com
+      deployment:
+        strategy: blue_green
+        traffic_shifting: CodeDeployDefault.ECSCanary10Percent5Minutes
+        alarms: [payments-5xx]
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+      path: /api
+      deployment:
+        strategy: blue_green
+        max_percent: 150
+  web:
+    image: web
+    x-autodock:
+      domain: web.example.com
+      deployment: {traffic_shifting: CodeDeployDefault.HalfAtATime}
+`)
+	project := Parse(path)
+
+	payments := project.Services["payments"]
+	config, err := ParseServiceConfig(project, &payments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.IsBlueGreen() || config.Deployment.TrafficShifting != "CodeDeployDefault.ECSCanary10Percent5Minutes" {
+		t.Errorf("Deployment = %+v; want a canary blue_green deployment", config.Deployment)
+	}
+
+	problems := Validate(path, project)
+	expected := []string{
+		path + ":17: service api: deployment.max_percent: is only used by rolling deployments",
+		path + ":16: service api: deployment.strategy: can't shift the traffic of a service served under a path, CodeDeploy only switches the default action of the load balancer",
+		path + `:22: service web: deployment.traffic_shifting: must be one of CodeDeployDefault.ECSAllAtOnce, CodeDeployDefault.ECSCanary10Percent5Minutes, CodeDeployDefault.ECSCanary10Percent15Minutes, CodeDeployDefault.ECSLinear10PercentEvery1Minutes, CodeDeployDefault.ECSLinear10PercentEvery3Minutes, got "CodeDeployDefault.HalfAtATime"`,
+		path + ":22: service web: deployment.traffic_shifting: is only used by blue_green deployments",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+}
+
+func TestSmokeChecks(t *testing.T) {
+	path := writeComposeFile(t, `services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+      smoke:
+        - path: /healthz
+          body: '"status":\s*"ok"'
+        - {path: /old, status: 301}
+      deployment: {rollback_on_smoke_failure: true}
+  admin:
+    image: admin
+    x-autodock:
+      domain: admin.example.com
+      visibility: internal
+      smoke:
+        - path: healthz
+          body: '(unclosed'
+  worker:
+    image: worker
+    x-autodock:
+      domain: worker.example.com
+      deployment: {rollback_on_smoke_failure: true}
+`)
+	project := Parse(path)
+
+	api := project.Services["api"]
+	config, err := ParseServiceConfig(project, &api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Smoke) != 2 || config.Smoke[1].Status != 301 || !config.Deployment.RollbackOnSmoke {
+		t.Errorf("smoke checks not read: %+v", config.Smoke)
+	}
+
+	problems := Validate(path, project)
+	expected := []string{
+		path + ":16: service admin: smoke: can't reach an internal service from outside the VPC",
+		path + ":17: service admin: smoke.0.path: must start with /",
+		path + ":18: service admin: smoke.0.body: is not a regular expression: error parsing regexp: missing closing ): `(unclosed`",
+		path + ":23: service worker: deployment.rollback_on_smoke_failure: needs smoke checks to roll back on",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package compose
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Fargate limits of the container settings
+const (
+	maxStopTimeout         = 120
+	minHealthCheckInterval = 5
+	maxHealthCheckInterval = 300
+	minHealthCheckTimeout  = 2
+	maxHealthCheckTimeout  = 60
+	minHealthCheckRetries  = 1
+	maxHealthCheckRetries  = 10
+	maxHealthCheckStart    = 300
+)
+
+// Kernel parameters Fargate lets tasks set, by prefix
+var fargateSysctlPrefixes = []string{"net.", "kernel.msg", "kernel.sem", "kernel.shm", "fs.mqueue."}
+
+// The only capability Fargate lets containers add
+const fargateCapability = "SYS_PTRACE"
+
+// Fields of a Compose service that are deployed, or that only matter when running it locally. Any other field that
+// is set is reported as not supported.
+var deployedFields = []string{
+	"name", "profiles", "attach", "build", "develop", "dockerfile", "pull_policy", "extends", "platform", "image",
+	"container_name", "depends_on", "deploy", "scale", "ports", "expose", "networks", "environment", "env_file",
+	"volumes", "command", "entrypoint", "working_dir", "user", "read_only", "init", "labels", "label_file",
+	"stdin_open", "tty", "ulimits", "stop_grace_period", "cap_add", "cap_drop", "sysctls", "healthcheck",
+	"#extensions",
+}
+
+// Why Fargate doesn't support a field of a Compose service, or what to set instead
+var unsupportedFields = map[string]string{
+	"privileged":          "Fargate doesn't run privileged containers",
+	"devices":             "Fargate tasks can't access host devices",
+	"device_cgroup_rules": "Fargate tasks can't access host devices",
+	"gpus":                "Fargate has no GPUs",
+	"extra_hosts":         "Fargate tasks can't add entries to /etc/hosts",
+	"hostname":            "Fargate tasks get the hostname of their network interface",
+	"domainname":          "Fargate tasks get the domain of their VPC",
+	"dns":                 "Fargate tasks use the resolver of their VPC",
+	"dns_search":          "Fargate tasks use the resolver of their VPC",
+	"dns_opt":             "Fargate tasks use the resolver of their VPC",
+	"links":               "services reach each other by their domain names",
+	"external_links":      "services reach each other by their domain names",
+	"mac_address":         "Fargate tasks have their own network interface",
+	"network_mode":        "Fargate tasks have their own network interface",
+	"net":                 "Fargate tasks have their own network interface",
+	"tmpfs":               "Fargate doesn't mount tmpfs, use an anonymous volume",
+	"shm_size":            "Fargate doesn't size /dev/shm",
+	"ipc":                 "Fargate doesn't share IPC namespaces",
+	"pid":                 "Fargate doesn't share PID namespaces",
+	"uts":                 "Fargate doesn't share UTS namespaces",
+	"userns_mode":         "Fargate doesn't remap users",
+	"security_opt":        "Fargate doesn't set security options",
+	"stop_signal":         "Fargate stops containers with SIGTERM",
+	"group_add":           "Fargate doesn't add groups, set them in the user",
+	"pids_limit":          "Fargate doesn't limit processes",
+	"oom_score_adj":       "Fargate doesn't adjust OOM scores",
+	"oom_kill_disable":    "Fargate doesn't disable the OOM killer",
+	"restart":             "ECS replaces the stopped tasks of services",
+	"logging":             "the logs of the containers go to CloudWatch Logs",
+	"log_driver":          "the logs of the containers go to CloudWatch Logs",
+	"log_opt":             "the logs of the containers go to CloudWatch Logs",
+	"mem_limit":           fmt.Sprintf("set the memory of the task in %s.size", ExtensionKey),
+	"mem_reservation":     fmt.Sprintf("set the memory of the task in %s.size", ExtensionKey),
+	"memswap_limit":       "Fargate tasks don't swap",
+	"mem_swappiness":      "Fargate tasks don't swap",
+	"cpus":                fmt.Sprintf("set the CPU of the task in %s.size", ExtensionKey),
+	"cpu_shares":          fmt.Sprintf("set the CPU of the task in %s.size", ExtensionKey),
+	"cpu_count":           fmt.Sprintf("set the CPU of the task in %s.size", ExtensionKey),
+	"cpu_percent":         fmt.Sprintf("set the CPU of the task in %s.size", ExtensionKey),
+	"cpu_period":          fmt.Sprintf("set the CPU of the task in %s.size", ExtensionKey),
+	"cpu_quota":           fmt.Sprintf("set the CPU of the task in %s.size", ExtensionKey),
+	"cpuset":              "Fargate doesn't pin containers to CPUs",
+	"cgroup_parent":       "Fargate places containers in its own cgroups",
+	"cgroup":              "Fargate places containers in its own cgroups",
+	"blkio_config":        "Fargate doesn't limit block IO",
+	"storage_opt":         "Fargate doesn't set storage driver options",
+	"secrets":             "Compose secrets aren't deployed, pass them as environment variables",
+	"configs":             "Compose configs aren't deployed, copy them into the image",
+	"volumes_from":        "mount the named volumes of the other service instead",
+	"volume_driver":       "Fargate mounts EFS volumes",
+	"post_start":          "Fargate doesn't run lifecycle hooks",
+	"pre_stop":            "Fargate doesn't run lifecycle hooks",
+	"runtime":             "Fargate runs containers with its own runtime",
+	"isolation":           "Fargate runs containers with its own runtime",
+}
+
+// Settings of the container of a service, translated from its Compose definition within the limits of Fargate.
+// Durations are in seconds.
+type Container struct {
+	Command          []string
+	EntryPoint       []string
+	WorkingDirectory string
+	User             string
+	Ulimits          []Ulimit
+	StopTimeout      int // 0 keeps the default of 30 seconds
+	ReadOnly         bool
+	Init             bool
+	CapAdd           []string
+	CapDrop          []string
+	Sysctls          map[string]string
+	Labels           map[string]string
+	Interactive      bool
+	Tty              bool
+	HealthCheck      *ContainerHealthCheck
+}
+
+type Ulimit struct {
+	Name string
+	Soft int
+	Hard int
+}
+
+type ContainerHealthCheck struct {
+	Command     []string // starting with CMD or CMD-SHELL
+	Interval    int
+	Timeout     int
+	Retries     int
+	StartPeriod int
+}
+
+// Round a Compose duration up to whole seconds, a nil one being the default
+func seconds(duration *types.Duration, defaultSeconds int) int {
+	if duration == nil {
+		return defaultSeconds
+	}
+	return int(math.Ceil(time.Duration(*duration).Seconds()))
+}
+
+// Translate the Compose definition of a service to the settings of its container on Fargate. Returns the report of
+// the settings Fargate doesn't support, which aren't deployed, or only partially.
+func FargateContainer(service *types.ServiceConfig) (*Container, []string) {
+	warnings := []string{}
+	container := &Container{
+		Command:          service.Command,
+		EntryPoint:       service.Entrypoint,
+		WorkingDirectory: service.WorkingDir,
+		User:             service.User,
+		ReadOnly:         service.ReadOnly,
+		Init:             service.Init != nil && *service.Init,
+		Labels:           service.Labels,
+		Interactive:      service.StdinOpen,
+		Tty:              service.Tty,
+	}
+
+	names := []string{}
+	for name := range service.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ulimit := service.Ulimits[name]
+		soft, hard := ulimit.Soft, ulimit.Hard
+		if ulimit.Single != 0 {
+			soft, hard = ulimit.Single, ulimit.Single
+		}
+		container.Ulimits = append(container.Ulimits, Ulimit{Name: name, Soft: soft, Hard: hard})
+	}
+
+	if service.StopGracePeriod != nil {
+		container.StopTimeout = seconds(service.StopGracePeriod, 0)
+		if container.StopTimeout > maxStopTimeout {
+			warnings = append(warnings, fmt.Sprintf("stop_grace_period: Fargate waits at most %d seconds, not %d", maxStopTimeout, container.StopTimeout))
+			container.StopTimeout = maxStopTimeout
+		}
+	}
+
+	for _, capability := range service.CapAdd {
+		capability = strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+		if capability != fargateCapability {
+			warnings = append(warnings, fmt.Sprintf("cap_add: Fargate only adds %s, not %s", fargateCapability, capability))
+			continue
+		}
+		container.CapAdd = append(container.CapAdd, capability)
+	}
+	for _, capability := range service.CapDrop {
+		container.CapDrop = append(container.CapDrop, strings.TrimPrefix(strings.ToUpper(capability), "CAP_"))
+	}
+
+	names = []string{}
+	for name := range service.Sysctls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		supported := false
+		for _, prefix := range fargateSysctlPrefixes {
+			supported = supported || strings.HasPrefix(name, prefix)
+		}
+		if !supported {
+			warnings = append(warnings, fmt.Sprintf("sysctls: Fargate doesn't let tasks set %s", name))
+			continue
+		}
+		if container.Sysctls == nil {
+			container.Sysctls = map[string]string{}
+		}
+		container.Sysctls[name] = service.Sysctls[name]
+	}
+
+	if healthCheck := service.HealthCheck; healthCheck != nil && !healthCheck.Disable && len(healthCheck.Test) > 0 && healthCheck.Test[0] != "NONE" {
+		retries := 3
+		if healthCheck.Retries != nil {
+			retries = int(*healthCheck.Retries)
+		}
+		// the defaults of Docker, rather than the ones of ECS, to behave as locally
+		container.HealthCheck = &ContainerHealthCheck{
+			Command:     healthCheck.Test,
+			Interval:    seconds(healthCheck.Interval, 30),
+			Timeout:     seconds(healthCheck.Timeout, 30),
+			Retries:     retries,
+			StartPeriod: seconds(healthCheck.StartPeriod, 0),
+		}
+		limits := []struct {
+			field    string
+			value    *int
+			min, max int
+		}{
+			{"interval", &container.HealthCheck.Interval, minHealthCheckInterval, maxHealthCheckInterval},
+			{"timeout", &container.HealthCheck.Timeout, minHealthCheckTimeout, maxHealthCheckTimeout},
+			{"retries", &container.HealthCheck.Retries, minHealthCheckRetries, maxHealthCheckRetries},
+			{"start_period", &container.HealthCheck.StartPeriod, 0, maxHealthCheckStart},
+		}
+		for _, limit := range limits {
+			if *limit.value < limit.min || *limit.value > limit.max {
+				clamped := min(max(*limit.value, limit.min), limit.max)
+				warnings = append(warnings, fmt.Sprintf("healthcheck.%s: Fargate takes %d to %d, using %d instead of %d", limit.field, limit.min, limit.max, clamped, *limit.value))
+				*limit.value = clamped
+			}
+		}
+		if healthCheck.StartInterval != nil {
+			warnings = append(warnings, "healthcheck.start_interval: not supported by Fargate, checks run at the interval from the start")
+		}
+	}
+
+	if hasTmpfsVolume(service) && len(service.Tmpfs) == 0 {
+		warnings = append(warnings, fmt.Sprintf("tmpfs: %s", unsupportedFields["tmpfs"]))
+	}
+	if service.Deploy != nil && (service.Deploy.Resources.Limits != nil || service.Deploy.Resources.Reservations != nil) {
+		warnings = append(warnings, fmt.Sprintf("deploy.resources: set the size of the task in %s.size", ExtensionKey))
+	}
+	value := reflect.ValueOf(*service)
+	for i := range value.NumField() {
+		field, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("yaml"), ",")
+		if slices.Contains(deployedFields, field) || field == "-" || !isSet(value.Field(i)) {
+			continue
+		}
+		reason, ok := unsupportedFields[field]
+		if !ok {
+			reason = "not supported by Fargate, ignored"
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: %s", field, reason))
+	}
+	return container, warnings
+}
+
+// Whether a field of a Compose service is set, an empty list or mapping not being set
+func isSet(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() > 0
+	}
+	return !value.IsZero()
+}
+
+func hasTmpfsVolume(service *types.ServiceConfig) bool {
+	for _, volume := range service.Volumes {
+		if volume.Type == types.VolumeTypeTmpfs {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFargateContainer(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  api:
+    image: api
+    build: .
+    command: npm start
+    entrypoint: ["/sbin/tini", "--"]
+    working_dir: /app
+    user: node
+    read_only: true
+    init: true
+    stop_grace_period: 3m
+    cap_add: [CAP_SYS_PTRACE, NET_ADMIN]
+    cap_drop: [ALL]
+    sysctls:
+      net.core.somaxconn: 1024
+      vm.swappiness: 10
+    ulimits:
+      nproc: 65535
+      nofile: {soft: 20000, hard: 40000}
+    labels:
+      team: web
+    extra_hosts: ["db:10.0.0.5"]
+    tmpfs: /run
+    restart: always
+    logging:
+      driver: json-file
+    mac_address: 02:42:ac:11:00:02
+    cpuset: "0,1"
+    memswap_limit: 1g
+    annotations:
+      com.example.owner: web
+    healthcheck:
+      test: curl -f http://localhost:3000/healthz
+      interval: 1s
+      retries: 5
+    x-autodock:
+      domain: api.example.com
+`)
+	project := Parse(path)
+	api := project.Services["api"]
+	container, warnings := FargateContainer(&api)
+
+	expected := &Container{
+		Command:          []string{"npm", "start"},
+		EntryPoint:       []string{"/sbin/tini", "--"},
+		WorkingDirectory: "/app",
+		User:             "node",
+		Ulimits:          []Ulimit{{Name: "nofile", Soft: 20000, Hard: 40000}, {Name: "nproc", Soft: 65535, Hard: 65535}},
+		StopTimeout:      120,
+		ReadOnly:         true,
+		Init:             true,
+		CapAdd:           []string{"SYS_PTRACE"},
+		CapDrop:          []string{"ALL"},
+		Sysctls:          map[string]string{"net.core.somaxconn": "1024"},
+		Labels:           map[string]string{"team": "web"},
+		HealthCheck: &ContainerHealthCheck{
+			Command:  []string{"CMD-SHELL", "curl -f http://localhost:3000/healthz"},
+			Interval: 5,
+			Timeout:  30,
+			Retries:  5,
+		},
+	}
+	if !reflect.DeepEqual(container, expected) {
+		t.Errorf("FargateContainer() = %+v; want %+v", container, expected)
+	}
+
+	expectedWarnings := []string{
+		"stop_grace_period: Fargate waits at most 120 seconds, not 180",
+		"cap_add: Fargate only adds SYS_PTRACE, not NET_ADMIN",
+		"sysctls: Fargate doesn't let tasks set vm.swappiness",
+		"healthcheck.interval: Fargate takes 5 to 300, using 5 instead of 1",
+		"annotations: not supported by Fargate, ignored",
+		"cpuset: Fargate doesn't pin containers to CPUs",
+		"extra_hosts: Fargate tasks can't add entries to /etc/hosts",
+		"logging: the logs of the containers go to CloudWatch Logs",
+		"memswap_limit: Fargate tasks don't swap",
+		"mac_address: Fargate tasks have their own network interface",
+		"restart: ECS replaces the stopped tasks of services",
+		"tmpfs: Fargate doesn't mount tmpfs, use an anonymous volume",
+	}
+	if !reflect.DeepEqual(warnings, expectedWarnings) {
+		t.Errorf("FargateContainer() warnings = %q; want %q", warnings, expectedWarnings)
+	}
+}
@@ -0,0 +1,376 @@
+package compose
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Types of the resources that can be created in the resources stack, named like the LocalStack services emulating them
+var resourceTypes = []string{"s3", "sqs", "sns", "dynamodb"}
+
+var keyPattern = regexp.MustCompile(`^[^:]+(:[SNB])?$`)
+
+// Directories where LocalStack runs init hooks: the lifecycle stages, and the legacy entrypoint directory
+var localStackInitDirs = []string{"/etc/localstack/init/", "/docker-entrypoint-initaws.d"}
+
+// The LocalStack service of a project, nil when there is none
+func LocalStackService(project *types.Project) *types.ServiceConfig {
+	for _, name := range project.ServiceNames() {
+		service := project.Services[name]
+		if strings.HasPrefix(service.Image, "localstack/") || strings.Contains(service.Image, "/localstack/") {
+			return &service
+		}
+	}
+	return nil
+}
+
+// The resources used by the services, to create in AWS: the ones declared in x-autodock.resources,
+// followed by the ones created by the init hooks of the LocalStack service that aren't declared
+func Resources(project *types.Project) ([]ResourceConfig, error) {
+	config, err := ParseProjectConfig(project)
+	if err != nil {
+		return nil, err
+	}
+	resources := append([]ResourceConfig{}, config.Resources...)
+
+	localStack := LocalStackService(project)
+	if localStack == nil {
+		return resources, nil
+	}
+	discovered, err := localStackResources(localStack)
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range discovered {
+		declared := slices.ContainsFunc(resources, func(r ResourceConfig) bool {
+			return r.Type == resource.Type && r.Name == resource.Name
+		})
+		if !declared {
+			resources = append(resources, resource)
+		}
+	}
+	return resources, nil
+}
+
+// The AWS services the tasks of a project call to use its resources, among the resource types, sorted: the types of
+// the resources created in the resources stack, and of the iam grants of the deployed services
+func ResourceAPIs(project *types.Project) ([]string, error) {
+	resources, err := Resources(project)
+	if err != nil {
+		return nil, err
+	}
+	apis := []string{}
+	for _, resource := range resources {
+		apis = append(apis, resource.Type)
+	}
+	for _, service := range DeployedServices(project) {
+		config, err := ParseServiceConfig(project, &service)
+		if err != nil {
+			return nil, err
+		}
+		if iam := config.IAM; iam != nil {
+			grants := []struct {
+				api string
+				set bool
+			}{
+				{"s3", len(iam.S3) > 0},
+				{"sqs", len(iam.SQS) > 0},
+				{"sns", len(iam.SNS) > 0},
+				{"dynamodb", len(iam.DynamoDB) > 0},
+			}
+			for _, grant := range grants {
+				if grant.set {
+					apis = append(apis, grant.api)
+				}
+			}
+		}
+	}
+	sort.Strings(apis)
+	return slices.Compact(apis), nil
+}
+
+// Names of the services granted read-write access to a resource: the ones it lists, or else the deployed services
+// depending on the LocalStack service. Without a LocalStack service, a resource not listing its services grants
+// none, as nothing tells which services use it.
+func ResourceServices(project *types.Project, resource ResourceConfig) []string {
+	if len(resource.Services) > 0 {
+		return resource.Services
+	}
+	localStack := LocalStackService(project)
+	names := []string{}
+	if localStack == nil {
+		return names
+	}
+	for _, service := range DeployedServices(project) {
+		if dependsOn(&service, localStack.Name) {
+			names = append(names, service.Name)
+		}
+	}
+	return names
+}
+
+func dependsOn(service *types.ServiceConfig, name string) bool {
+	_, ok := service.DependsOn[name]
+	return ok
+}
+
+// Whether an environment variable of a service points its AWS SDK to LocalStack, and must not be deployed:
+// endpoint overrides, the dummy credentials LocalStack accepts, and any value referring to the LocalStack host
+func IsLocalStackOverride(project *types.Project, service *types.ServiceConfig, key string) bool {
+	localStack := LocalStackService(project)
+	if localStack == nil || !dependsOn(service, localStack.Name) {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(key, "AWS_ENDPOINT_URL"),
+		key == "AWS_ACCESS_KEY_ID", key == "AWS_SECRET_ACCESS_KEY", key == "AWS_SESSION_TOKEN",
+		strings.HasPrefix(key, "LOCALSTACK_"):
+		return true
+	}
+	value := service.Environment[key]
+	if value == nil {
+		return false
+	}
+	hosts := []string{localStack.Name, "localhost.localstack.cloud"}
+	if localStack.ContainerName != "" {
+		hosts = append(hosts, localStack.ContainerName)
+	}
+	for _, host := range hosts {
+		if strings.Contains(*value, "://"+host+":") || strings.Contains(*value, "://"+host+"/") || strings.HasSuffix(*value, "://"+host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the resources created by the init hook scripts mounted into a LocalStack service.
+// Resources of services missing from its SERVICES variable, when set, are not started by LocalStack and are skipped.
+func localStackResources(localStack *types.ServiceConfig) ([]ResourceConfig, error) {
+	enabled := []string{}
+	if services := localStack.Environment["SERVICES"]; services != nil && *services != "" {
+		for _, name := range strings.Split(*services, ",") {
+			enabled = append(enabled, strings.TrimSpace(name))
+		}
+	}
+
+	scripts := []string{}
+	for _, volume := range localStack.Volumes {
+		if volume.Type != types.VolumeTypeBind {
+			continue
+		}
+		isInitHook := slices.ContainsFunc(localStackInitDirs, func(dir string) bool {
+			return strings.HasPrefix(volume.Target, dir)
+		})
+		if !isInitHook {
+			continue
+		}
+		info, err := os.Stat(volume.Source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the LocalStack init hooks: %w", err)
+		}
+		if !info.IsDir() {
+			scripts = append(scripts, volume.Source)
+			continue
+		}
+		// hooks can be mounted as a directory of stages, or as the directory of a single stage
+		err = filepath.WalkDir(volume.Source, func(path string, entry os.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() && strings.HasSuffix(path, ".sh") {
+				scripts = append(scripts, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the LocalStack init hooks: %w", err)
+		}
+	}
+
+	resources := []ResourceConfig{}
+	for _, script := range scripts {
+		content, err := os.ReadFile(script)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the LocalStack init hooks: %w", err)
+		}
+		for _, resource := range parseInitScript(script, string(content)) {
+			if len(enabled) > 0 && !slices.Contains(enabled, resource.Type) {
+				log.Printf("[warn] Not creating %s %s from %s: %s is not in the SERVICES of LocalStack", resource.Type, resource.Name, script, resource.Type)
+				continue
+			}
+			resources = append(resources, resource)
+		}
+	}
+	return resources, nil
+}
+
+// Read the resources created by the awslocal (or aws) commands of a shell script
+func parseInitScript(script string, content string) []ResourceConfig {
+	resources := []ResourceConfig{}
+	lines := strings.Split(content, "\n")
+	for lineNumber := 0; lineNumber < len(lines); lineNumber++ {
+		// commands continued over several lines are read as one, and reported at their first line
+		line, start := lines[lineNumber], lineNumber
+		for strings.HasSuffix(line, "\\") && lineNumber+1 < len(lines) {
+			lineNumber++
+			line = strings.TrimSuffix(line, "\\") + " " + lines[lineNumber]
+		}
+		fields := shellFields(line)
+		command := slices.IndexFunc(fields, func(field string) bool { return field == "awslocal" || field == "aws" })
+		if command < 0 || command+2 >= len(fields) {
+			continue
+		}
+		args := fields[command+1:]
+		// global options such as --endpoint-url can come before the service
+		for len(args) > 2 && strings.HasPrefix(args[0], "--") {
+			if strings.Contains(args[0], "=") {
+				args = args[1:]
+			} else {
+				args = args[2:]
+			}
+		}
+
+		if len(args) < 2 {
+			continue
+		}
+
+		resource := ResourceConfig{}
+		switch args[0] + " " + args[1] {
+		case "s3 mb":
+			resource = ResourceConfig{Type: "s3", Name: strings.TrimSuffix(strings.TrimPrefix(positional(args[2:]), "s3://"), "/")}
+		case "s3api create-bucket":
+			resource = ResourceConfig{Type: "s3", Name: flagValue(args, "--bucket")}
+		case "sqs create-queue":
+			resource = ResourceConfig{Type: "sqs", Name: flagValue(args, "--queue-name")}
+		case "sns create-topic":
+			resource = ResourceConfig{Type: "sns", Name: flagValue(args, "--name")}
+		case "dynamodb create-table":
+			resource = ResourceConfig{Type: "dynamodb", Name: flagValue(args, "--table-name")}
+			resource.PartitionKey, resource.SortKey = tableKeys(args)
+		default:
+			continue
+		}
+		if resource.Name == "" || strings.Contains(resource.Name, "$") {
+			log.Printf("[warn] Not creating the %s resource of %s:%d, its name can't be read", resource.Type, script, start+1)
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	return resources
+}
+
+// Split a line of shell into words, honouring quotes and stopping at comments and command separators
+func shellFields(line string) []string {
+	fields := []string{}
+	var field strings.Builder
+	inField := false
+	var quote rune
+	for _, char := range line {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(char)
+		case char == '\'' || char == '"':
+			quote, inField = char, true
+		case char == '#' && !inField:
+			return fields
+		case char == ';' || char == '&' || char == '|':
+			if inField {
+				fields = append(fields, field.String())
+			}
+			return fields
+		case char == ' ' || char == '\t' || char == '\r':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(char)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// Values following a flag, as --flag value1 value2 or --flag=value
+func flagValues(args []string, flag string) []string {
+	for i, arg := range args {
+		if value, ok := strings.CutPrefix(arg, flag+"="); ok {
+			return []string{value}
+		}
+		if arg != flag {
+			continue
+		}
+		values := []string{}
+		for _, value := range args[i+1:] {
+			if strings.HasPrefix(value, "--") {
+				break
+			}
+			values = append(values, value)
+		}
+		return values
+	}
+	return nil
+}
+
+func flagValue(args []string, flag string) string {
+	if values := flagValues(args, flag); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// First argument that isn't a flag
+func positional(args []string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			return arg
+		}
+	}
+	return ""
+}
+
+// Keys of a create-table command, as name:type, from its shorthand --key-schema and --attribute-definitions
+func tableKeys(args []string) (string, string) {
+	attributeTypes := map[string]string{}
+	for _, definition := range flagValues(args, "--attribute-definitions") {
+		attributes := shorthandAttributes(definition)
+		attributeTypes[attributes["AttributeName"]] = attributes["AttributeType"]
+	}
+	partitionKey, sortKey := "", ""
+	for _, key := range flagValues(args, "--key-schema") {
+		attributes := shorthandAttributes(key)
+		name := attributes["AttributeName"]
+		if attributeType := attributeTypes[name]; attributeType != "" {
+			name += ":" + attributeType
+		}
+		switch attributes["KeyType"] {
+		case "HASH":
+			partitionKey = name
+		case "RANGE":
+			sortKey = name
+		}
+	}
+	return partitionKey, sortKey
+}
+
+// Parse AWS CLI shorthand syntax, e.g. AttributeName=id,KeyType=HASH
+func shorthandAttributes(value string) map[string]string {
+	attributes := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,87 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInitScript(t *testing.T) {
+	script := `#!/bin/bash
+set -e
+awslocal s3 mb s3://user-uploads
+awslocal sqs create-queue --queue-name jobs.fifo --attributes FifoQueue=true
+aws --endpoint-url=http://localhost:4566 sns create-topic --name "events" # notifications
+awslocal dynamodb create-table \
+  --table-name users \
+  --key-schema AttributeName=pk,KeyType=HASH AttributeName=sk,KeyType=RANGE \
+  --attribute-definitions AttributeName=pk,AttributeType=S AttributeName=sk,AttributeType=N
+awslocal s3api create-bucket --bucket=$BUCKET
+echo "awslocal is ready"
+`
+	expected := []ResourceConfig{
+		{Type: "s3", Name: "user-uploads"},
+		{Type: "sqs", Name: "jobs.fifo"},
+		{Type: "sns", Name: "events"},
+		{Type: "dynamodb", Name: "users", PartitionKey: "pk:S", SortKey: "sk:N"},
+	}
+	resources := parseInitScript("init.sh", script)
+	if !reflect.DeepEqual(resources, expected) {
+		t.Errorf("parseInitScript() = %+v; want %+v", resources, expected)
+	}
+}
+
+func TestResourceServices(t *testing.T) {
+	path := writeComposeFile(t, `
+x-autodock:
+  resources:
+    - {type: s3, name: uploads}
+    - {type: sqs, name: jobs, services: [worker]}
+services:
+  api:
+    image: api
+    x-autodock:
+      domain: api.example.com
+  worker:
+    image: worker
+    x-autodock:
+      schedule: rate(1 hour)
+`)
+	project := Parse(path)
+	resources, err := Resources(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a LocalStack service, only the listed services are granted access
+	if services := ResourceServices(project, resources[0]); len(services) != 0 {
+		t.Errorf("ResourceServices(uploads) = %v; want none", services)
+	}
+	if services := ResourceServices(project, resources[1]); !reflect.DeepEqual(services, []string{"worker"}) {
+		t.Errorf("ResourceServices(jobs) = %v; want [worker]", services)
+	}
+}
+
+func TestResourceAPIs(t *testing.T) {
+	path := writeComposeFile(t, `
+x-autodock:
+  resources:
+    - {type: sqs, name: jobs, services: [api]}
+    - {type: s3, name: uploads, services: [api]}
+    - {type: sqs, name: results, services: [api]}
+services:
+  api:
+    image: api
+    build: .
+    x-autodock:
+      domain: api.example.com
+      iam:
+        dynamodb: {table: users}
+`)
+	project := Parse(path)
+	apis, err := ResourceAPIs(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(apis, []string{"dynamodb", "s3", "sqs"}) {
+		t.Errorf("ResourceAPIs() = %v; want [dynamodb s3 sqs]", apis)
+	}
+}
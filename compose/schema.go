@@ -0,0 +1,111 @@
+package compose
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// JSON Schema of the x-autodock blocks, for completion and validation in editors.
+// It describes a whole Compose file but only constrains the x-autodock blocks, every other key is left to the Compose schema.
+func JSONSchema() ([]byte, error) {
+	definitions := map[string]any{}
+	schema := map[string]any{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "autodock extensions for Docker Compose files",
+		"type":        "object",
+		"definitions": definitions,
+		"properties": map[string]any{
+			ExtensionKey: schemaOf(reflect.TypeOf(ProjectConfig{}), definitions),
+			"services": map[string]any{
+				"type": "object",
+				"additionalProperties": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						ExtensionKey: schemaOf(reflect.TypeOf(ServiceConfig{}), definitions),
+					},
+				},
+			},
+		},
+	}
+	content, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+// Schema of a Go type, read from its yaml, desc and enum struct tags. Structs are added to definitions and referenced.
+func schemaOf(t reflect.Type, definitions map[string]any) map[string]any {
+	if t.Implements(reflect.TypeOf((*oneOrMany)(nil)).Elem()) {
+		item := schemaOf(t.Elem(), definitions)
+		return map[string]any{"oneOf": []any{item, map[string]any{"type": "array", "items": item}}}
+	}
+	if t.Kind() == reflect.Struct && t.Implements(reflect.TypeOf((*stringShorthand)(nil)).Elem()) {
+		return map[string]any{"oneOf": []any{map[string]any{"type": "string"}, structSchema(t, definitions)}}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), definitions)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), definitions)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), definitions)}
+	case reflect.Struct:
+		return structSchema(t, definitions)
+	}
+	return map[string]any{}
+}
+
+// Schema of a struct, added to definitions and referenced
+func structSchema(t reflect.Type, definitions map[string]any) map[string]any {
+	ref := map[string]any{"$ref": "#/definitions/" + t.Name()}
+	if _, ok := definitions[t.Name()]; ok {
+		return ref
+	}
+	properties := map[string]any{}
+	definition := map[string]any{"type": "object", "additionalProperties": false, "properties": properties}
+	// registered before visiting the fields so that recursive types terminate
+	definitions[t.Name()] = definition
+
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		property := schemaOf(field.Type, definitions)
+		if desc := field.Tag.Get("desc"); desc != "" {
+			property = withDescription(property, desc)
+		}
+		if enum := field.Tag.Get("enum"); enum != "" {
+			property["enum"] = strings.Split(enum, ",")
+		}
+		properties[name] = property
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	if len(required) > 0 {
+		definition["required"] = required
+	}
+	return ref
+}
+
+// Attach a description to a schema. $ref siblings are ignored by draft-07, so references are wrapped in allOf.
+func withDescription(schema map[string]any, description string) map[string]any {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]any{"allOf": []any{schema}, "description": description}
+	}
+	schema["description"] = description
+	return schema
+}
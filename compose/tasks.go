@@ -0,0 +1,188 @@
+package compose
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"gopkg.in/yaml.v3"
+)
+
+// A container of the task of a service
+type TaskContainer struct {
+	Service   types.ServiceConfig
+	Essential bool              // the task stops when an essential container stops
+	DependsOn map[string]string // ECS condition (START, HEALTHY or SUCCESS) of the other containers of the task it waits for, by service name
+}
+
+// ECS conditions of the Compose depends_on conditions
+var containerConditions = map[string]string{
+	types.ServiceConditionStarted:               "START",
+	types.ServiceConditionHealthy:               "HEALTHY",
+	types.ServiceConditionCompletedSuccessfully: "SUCCESS",
+}
+
+// Name of the task a service is grouped in, empty when it has a task of its own. Read from the raw block, as parsing
+// the block of a service checks its task against the other services of the task.
+func taskName(service *types.ServiceConfig) string {
+	value, ok := service.Extensions[ExtensionKey]
+	if !ok {
+		return ""
+	}
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	var block struct {
+		Task string `yaml:"task"`
+	}
+	if err := yaml.Unmarshal(content, &block); err != nil {
+		return ""
+	}
+	return block.Task
+}
+
+// The services grouped in a task, sorted by name
+func taskMembers(project *types.Project, name string) []types.ServiceConfig {
+	members := []types.ServiceConfig{}
+	for _, serviceName := range project.ServiceNames() {
+		service := project.Services[serviceName]
+		if name != "" && taskName(&service) == name {
+			members = append(members, service)
+		}
+	}
+	return members
+}
+
+// Whether the block of a service makes it run its task: the container with a domain is load balanced, the one with
+// a schedule is run by the scheduler. Defaults are applied, as the checks don't run on the other services of a task.
+func runsTask(project *types.Project, service *types.ServiceConfig) bool {
+	config := &ServiceConfig{}
+	if err := decodeStrict(service.Extensions[ExtensionKey], config); err != nil {
+		return false
+	}
+	config.applyLegacyDomain(service)
+	if projectConfig, err := ParseProjectConfig(project); err == nil {
+		config.applyDefaults(projectConfig.Defaults)
+	}
+	return len(config.Domain) > 0 || config.Schedule != ""
+}
+
+// The service running the task a service is grouped in, empty when there is none
+func taskRunner(project *types.Project, service *types.ServiceConfig) string {
+	for _, member := range taskMembers(project, taskName(service)) {
+		if runsTask(project, &member) {
+			return member.Name
+		}
+	}
+	return ""
+}
+
+// Whether a service is a sidecar, running in the task of another service instead of being deployed on its own
+func IsSidecar(project *types.Project, service *types.ServiceConfig) bool {
+	runner := taskRunner(project, service)
+	return runner != "" && runner != service.Name
+}
+
+// The containers of the task of a service: its own first, which is the load-balanced one, then the ones of its
+// sidecars sorted by name. Sidecars are essential unless their block says otherwise or another container of the
+// task waits for them to complete.
+func TaskContainers(project *types.Project, service *types.ServiceConfig) []TaskContainer {
+	services := []types.ServiceConfig{*service}
+	for _, member := range taskMembers(project, taskName(service)) {
+		if member.Name != service.Name {
+			services = append(services, member)
+		}
+	}
+
+	awaited := map[string]bool{}
+	for _, member := range services {
+		for name, dependency := range member.DependsOn {
+			if dependency.Condition == types.ServiceConditionCompletedSuccessfully {
+				awaited[name] = true
+			}
+		}
+	}
+
+	containers := []TaskContainer{}
+	for i, member := range services {
+		container := TaskContainer{Service: member, Essential: true, DependsOn: map[string]string{}}
+		if i > 0 {
+			config := &ServiceConfig{}
+			if err := decodeStrict(member.Extensions[ExtensionKey], config); err == nil && config.Essential != nil {
+				container.Essential = *config.Essential
+			} else {
+				container.Essential = !awaited[member.Name]
+			}
+		}
+		for name, dependency := range member.DependsOn {
+			for _, other := range services {
+				if other.Name == name {
+					container.DependsOn[name] = containerConditions[dependency.Condition]
+				}
+			}
+		}
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+// Check the task of a service against the other services of the task, from its block before defaults are applied
+func (c *ServiceConfig) checkTask(project *types.Project, service *types.ServiceConfig) []fieldError {
+	errs := []fieldError{}
+	if c.Task == "" {
+		if c.Essential != nil {
+			errs = append(errs, fieldError{"essential", "only applies to the sidecars of a task"})
+		}
+		return errs
+	}
+	if c.IsStatic() {
+		return append(errs, fieldError{"task", "static sites have no task"})
+	}
+
+	members := taskMembers(project, c.Task)
+	runners := []string{}
+	for _, member := range members {
+		if runsTask(project, &member) {
+			runners = append(runners, member.Name)
+		}
+	}
+	runner := runsTask(project, service)
+	if len(runners) > 1 && runner {
+		errs = append(errs, fieldError{"task", fmt.Sprintf("%s all have a domain or a schedule, only one service of task %s can", strings.Join(runners, ", "), c.Task)})
+	}
+
+	if len(runners) > 0 && !runner {
+		// settings of the task are read from the service running it
+		value := reflect.ValueOf(c).Elem()
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "task" || name == "essential" || value.Field(i).IsZero() {
+				continue
+			}
+			errs = append(errs, fieldError{name, fmt.Sprintf("is set by %s, which runs task %s", runners[0], c.Task)})
+		}
+	} else if c.Essential != nil {
+		errs = append(errs, fieldError{"essential", "only applies to the sidecars of a task, the container running it is always essential"})
+	}
+
+	names := []string{}
+	for name := range service.DependsOn {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if service.DependsOn[name].Condition != types.ServiceConditionHealthy {
+			continue
+		}
+		for _, member := range members {
+			if member.Name == name && (member.HealthCheck == nil || member.HealthCheck.Disable) {
+				errs = append(errs, fieldError{"task", fmt.Sprintf("waits for %s to be healthy, which has no healthcheck", name)})
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,114 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTaskContainers(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  proxy:
+    image: nginx
+    depends_on:
+      app: {condition: service_healthy}
+    x-autodock:
+      task: web
+      domain: web.example.com
+  app:
+    image: app
+    build: .
+    depends_on:
+      assets: {condition: service_completed_successfully}
+      migrate: {condition: service_completed_successfully}
+    healthcheck: {test: ["CMD", "true"]}
+    x-autodock:
+      task: web
+  assets:
+    image: assets
+    build: ./assets
+    x-autodock:
+      task: web
+  logs:
+    image: fluent-bit
+    x-autodock:
+      task: web
+      essential: false
+  migrate:
+    image: app
+    build: .
+`)
+	project := Parse(path)
+
+	names := []string{}
+	for _, service := range DeployedServices(project) {
+		names = append(names, service.Name)
+	}
+	if expected := []string{"migrate", "proxy"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("DeployedServices() = %v; want %v", names, expected)
+	}
+
+	proxy := project.Services["proxy"]
+	if hooks := PredeployHooks(project, &proxy); !reflect.DeepEqual(hooks, []string{"migrate"}) {
+		t.Errorf("PredeployHooks() = %v; want [migrate]", hooks)
+	}
+
+	type container struct {
+		name      string
+		essential bool
+		dependsOn map[string]string
+	}
+	containers := []container{}
+	for _, c := range TaskContainers(project, &proxy) {
+		containers = append(containers, container{c.Service.Name, c.Essential, c.DependsOn})
+	}
+	expected := []container{
+		{"proxy", true, map[string]string{"app": "HEALTHY"}},
+		{"app", true, map[string]string{"assets": "SUCCESS"}},
+		{"assets", false, map[string]string{}},
+		{"logs", false, map[string]string{}},
+	}
+	if !reflect.DeepEqual(containers, expected) {
+		t.Errorf("TaskContainers() = %+v; want %+v", containers, expected)
+	}
+}
+
+func TestValidateTasks(t *testing.T) {
+	path := writeComposeFile(t, `services:
+  api:
+    image: api
+    x-autodock:
+      task: web
+      domain: api.example.com
+      essential: true
+  admin:
+    image: admin
+    depends_on:
+      logs: {condition: service_healthy}
+    x-autodock:
+      task: web
+      domain: admin.example.com
+  logs:
+    image: logs
+    x-autodock:
+      task: web
+      size: {cpu: 256, memory: 512}
+`)
+	project := Parse(path)
+	problems := Validate(path, project)
+	expected := []string{
+		path + ":13: service admin: task: admin, api all have a domain or a schedule, only one service of task web can",
+		path + ":13: service admin: task: waits for logs to be healthy, which has no healthcheck",
+		path + ":5: service api: task: admin, api all have a domain or a schedule, only one service of task web can",
+		path + ":7: service api: essential: only applies to the sidecars of a task, the container running it is always essential",
+		path + ":19: service logs: size: is set by admin, which runs task web",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Validate() = %v; want %v", problems, expected)
+	}
+	for i, problem := range problems {
+		if problem.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, problem.String(), expected[i])
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package compose
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// A volume mounted in the container of a deployed service
+type VolumeMount struct {
+	Volume   string // name of the named volume in the Compose file, empty for an anonymous volume
+	Target   string
+	ReadOnly bool
+}
+
+// The volumes mounted by a service. Named volumes are persisted on EFS, anonymous ones only live as long as the
+// task. Host paths can't be mounted, Fargate tasks don't run on a host of ours; tmpfs mounts are reported with the
+// other settings of the container Fargate doesn't support.
+func ServiceVolumes(service *types.ServiceConfig) ([]VolumeMount, error) {
+	mounts := []VolumeMount{}
+	for _, volume := range service.Volumes {
+		switch volume.Type {
+		case types.VolumeTypeVolume:
+			mounts = append(mounts, VolumeMount{Volume: volume.Source, Target: volume.Target, ReadOnly: volume.ReadOnly})
+		case types.VolumeTypeTmpfs:
+			continue
+		case types.VolumeTypeBind:
+			return nil, fmt.Errorf("service %s mounts the host path %s at %s, which can't be deployed to Fargate: use a named volume, persisted on EFS, or copy the files into the image",
+				service.Name, volume.Source, volume.Target)
+		default:
+			return nil, fmt.Errorf("service %s mounts a volume of type %s at %s, only named volumes can be deployed", service.Name, volume.Type, volume.Target)
+		}
+	}
+	return mounts, nil
+}
+
+// The named volumes mounted by the containers of deployed services, sorted, each becoming an EFS file system
+func PersistentVolumes(project *types.Project) []string {
+	volumes := []string{}
+	for _, service := range DeployedServices(project) {
+		config, err := ParseServiceConfig(project, &service)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		if config.IsStatic() {
+			continue
+		}
+		for _, container := range TaskContainers(project, &service) {
+			mounts, err := ServiceVolumes(&container.Service)
+			if err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+			for _, mount := range mounts {
+				if mount.Volume != "" && !slices.Contains(volumes, mount.Volume) {
+					volumes = append(volumes, mount.Volume)
+				}
+			}
+		}
+	}
+	sort.Strings(volumes)
+	return volumes
+}
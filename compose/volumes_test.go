@@ -0,0 +1,54 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServiceVolumes(t *testing.T) {
+	path := writeComposeFile(t, `
+services:
+  api:
+    image: api
+    build: .
+    volumes:
+      - uploads:/app/uploads
+      - cache:/app/cache:ro
+      - /tmp/scratch
+      - type: tmpfs
+        target: /run
+    x-autodock:
+      domain: api.example.com
+  worker:
+    image: worker
+    build: .
+    volumes:
+      - ./src:/app/src
+    x-autodock:
+      domain: worker.example.com
+volumes:
+  uploads:
+  cache:
+`)
+	project := Parse(path)
+
+	api := project.Services["api"]
+	mounts, err := ServiceVolumes(&api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []VolumeMount{
+		{Volume: "uploads", Target: "/app/uploads"},
+		{Volume: "cache", Target: "/app/cache", ReadOnly: true},
+		{Target: "/tmp/scratch"},
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Errorf("ServiceVolumes() = %+v; want %+v", mounts, expected)
+	}
+
+	worker := project.Services["worker"]
+	if _, err := ServiceVolumes(&worker); err == nil || !strings.Contains(err.Error(), "host path") {
+		t.Errorf("expected a bind mount to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"autodock/aws"
+	"autodock/aws/cfntemplate"
+	"autodock/compose"
+	"fmt"
+	"log"
+	"strings"
+
+	composeTypes "github.com/compose-spec/compose-go/v2/types"
+)
+
+// Find the hosted zone and certificate of each root domain of the services, before generating the templates
+func resolveRootDomains(project *composeTypes.Project) []cfntemplate.RootDomain {
+	config, err := compose.ParseProjectConfig(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	rootDomains := []cfntemplate.RootDomain{}
+	for _, name := range cfntemplate.RootDomains(project) {
+		rootDomains = append(rootDomains, resolveRootDomain(project, config.Domain(name), name))
+	}
+	return rootDomains
+}
+
+// Find the hosted zone and certificate of a root domain: the ones set in x-autodock.domains, or else the public
+// hosted zone of the domain in Route53, in which a certificate is created
+func resolveRootDomain(project *composeTypes.Project, domainConfig compose.DomainConfig, name string) cfntemplate.RootDomain {
+	resolved := cfntemplate.RootDomain{Name: name, HostedZoneID: domainConfig.HostedZoneID, CertificateARN: domainConfig.CertificateARN}
+	if resolved.HostedZoneID != "" {
+		return resolved
+	}
+
+	// earlier versions always created the zone in the bootstrap stack, and the services import it
+	if aws.StackHasResource(ctx, fmt.Sprintf("%s-bootstrap", project.Name), cfntemplate.HostedZoneResourceName(name)) {
+		log.Printf("[info] Keeping the hosted zone of %s managed by the bootstrap stack", name)
+		return resolved
+	}
+
+	zones, err := aws.LookupHostedZones(ctx, name)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	switch {
+	case len(zones) == 1:
+		resolved.HostedZoneID = zones[0]
+		log.Printf("[info] Using the hosted zone %s of %s", resolved.HostedZoneID, name)
+	case len(zones) > 1:
+		log.Fatalf("[error] Route53 has %d public hosted zones for %s (%s), set the hosted_zone_id of the one the domain is delegated to in x-autodock.domains",
+			len(zones), name, strings.Join(zones, ", "))
+	case domainConfig.CreateHostedZone:
+		log.Fatalf("[error] The hosted zone of %s doesn't exist yet, `autodock bootstrap` creates it", name)
+	default:
+		log.Fatalf("[error] Route53 has no public hosted zone for %s. Create it, or set create_hosted_zone: true for %s in x-autodock.domains to let autodock create it.", name, name)
+	}
+	return resolved
+}
+
+// Create the hosted zones of the root domains set to create_hosted_zone that don't have one yet, and print the
+// NS records to delegate them to
+func createHostedZones(project *composeTypes.Project) {
+	config, err := compose.ParseProjectConfig(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	for _, name := range cfntemplate.RootDomains(project) {
+		if domainConfig := config.Domain(name); !domainConfig.CreateHostedZone {
+			continue
+		}
+		if aws.StackHasResource(ctx, fmt.Sprintf("%s-bootstrap", project.Name), cfntemplate.HostedZoneResourceName(name)) {
+			continue
+		}
+		zones, err := aws.LookupHostedZones(ctx, name)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		if len(zones) > 0 {
+			continue
+		}
+		zone, err := aws.CreateHostedZone(ctx, name)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		fmt.Printf("\nCreated the hosted zone %s of %s. Delegate the domain to it with these NS records at its registrar or parent zone,\n", zone.ID, name)
+		fmt.Println("its certificate is validated, and the bootstrap stack completes, once they are in place:")
+		for _, nameServer := range zone.NameServers {
+			fmt.Printf("  %s. NS %s.\n", name, nameServer)
+		}
+		fmt.Println()
+	}
+}
+
+// IDs of the hosted zones of the root domains, by name. The exports of the bootstrap stack can't be imported from
+// another region, so the IDs of the zones it manages are read from its outputs.
+func hostedZoneIDs(project *composeTypes.Project, rootDomains []cfntemplate.RootDomain) map[string]string {
+	ids := map[string]string{}
+	var outputs map[string]string
+	for _, domain := range rootDomains {
+		if domain.HostedZoneID != "" {
+			ids[domain.Name] = domain.HostedZoneID
+			continue
+		}
+		if outputs == nil {
+			var err error
+			outputs, err = aws.StackOutputs(ctx, "", fmt.Sprintf("%s-bootstrap", project.Name))
+			if err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+		}
+		ids[domain.Name] = outputs[cfntemplate.HostedZoneResourceName(domain.Name)]
+	}
+	return ids
+}
@@ -6,9 +6,14 @@ import (
 	"autodock/compose"
 	"autodock/docker"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	composeTypes "github.com/compose-spec/compose-go/v2/types"
 	"github.com/spf13/cobra"
@@ -20,18 +25,294 @@ var composeFile string
 var ctx = context.Background()
 
 // Bootstrap the cloud account with required resources needed for deployments, such as a Docker registry"
-func bootstrap(project *composeTypes.Project) {
-	y := cfntemplate.GenerateBootstrapTemplate(project)
+// Returns the hosted zones and certificates of the root domains of the services.
+func bootstrap(project *composeTypes.Project, network *cfntemplate.Network) []cfntemplate.RootDomain {
+	createHostedZones(project)
+	rootDomains := resolveRootDomains(project)
+	y := cfntemplate.GenerateBootstrapTemplate(project, network, rootDomains)
 	if err := aws.StackDeploy(ctx, fmt.Sprintf("%s-bootstrap", project.Name), y); err != nil {
 		log.Fatalf("[error] Error deploying Bootstrap stack: %s\n", err)
 	}
+	return rootDomains
 }
 
-// Build Docker images for services in a Compose file
-func build(service *composeTypes.ServiceConfig) string {
-	imageTag := docker.BuildImage(ctx, service)
-	docker.PushImage(ctx, service, imageTag)
-	return imageTag
+// Create the buckets, queues, topics and tables used by the services, when there are any
+func deployResources(project *composeTypes.Project) {
+	y := cfntemplate.GenerateResourcesTemplate(project)
+	if y == "" {
+		return
+	}
+	if err := aws.StackDeploy(ctx, fmt.Sprintf("%s-resources", project.Name), y); err != nil {
+		log.Fatalf("[error] Error deploying resources stack: %s\n", err)
+	}
+}
+
+// Create the us-east-1 certificates of the services served through CloudFront, when there are any
+func deployCDNCertificates(project *composeTypes.Project, rootDomains []cfntemplate.RootDomain) {
+	y := cfntemplate.GenerateCDNCertificateTemplate(project, hostedZoneIDs(project, rootDomains))
+	if y == "" {
+		return
+	}
+	if err := aws.StackDeployInRegion(ctx, cfntemplate.CDNRegion, cfntemplate.CDNCertificateStackName(project), y); err != nil {
+		log.Fatalf("[error] Error deploying CDN certificates stack: %s\n", err)
+	}
+}
+
+// ARN of the certificate of the distribution of a service, from the outputs of the us-east-1 stack
+func cdnCertificateARN(project *composeTypes.Project, service *composeTypes.ServiceConfig) string {
+	outputs, err := aws.StackOutputs(ctx, cfntemplate.CDNRegion, cfntemplate.CDNCertificateStackName(project))
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	arn, ok := outputs[cfntemplate.CDNCertificateResourceName(service)]
+	if !ok {
+		log.Fatalf("[error] The stack %s in %s has no certificate for %s", cfntemplate.CDNCertificateStackName(project), cfntemplate.CDNRegion, service.Name)
+	}
+	return arn
+}
+
+// Upload the files of a static site to its bucket, and invalidate the copies cached by its distribution
+func uploadStatic(project *composeTypes.Project, service *composeTypes.ServiceConfig, dir string) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(project.WorkingDir, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		log.Fatalf("[error] The static directory %s of %s doesn't exist, build the site first", dir, service.Name)
+	}
+	outputs, err := aws.StackOutputs(ctx, "", fmt.Sprintf("%s-%s", project.Name, service.Name))
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	count, err := aws.UploadStatic(ctx, outputs[cfntemplate.StaticBucketOutput], dir)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	if err := aws.InvalidateDistribution(ctx, outputs[cfntemplate.DistributionOutput]); err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	log.Printf("[info] Uploaded %d file(s) of %s", count, service.Name)
+}
+
+// Build and push the Docker images of the containers of the task of a service that are built from source.
+// Returns their tags by service name.
+func build(project *composeTypes.Project, service *composeTypes.ServiceConfig) map[string]string {
+	imageTags := map[string]string{}
+	for _, container := range compose.TaskContainers(project, service) {
+		if container.Service.Build == nil {
+			continue
+		}
+		imageTag := docker.BuildImage(ctx, &container.Service)
+		docker.PushImage(ctx, &container.Service, imageTag)
+		imageTags[container.Service.Name] = imageTag
+	}
+	return imageTags
+}
+
+// Describe a one-off task running the given command with the task definition of a deployed service
+func oneOffTask(project *composeTypes.Project, network *cfntemplate.Network, service *composeTypes.ServiceConfig, command []string) aws.OneOffTask {
+	config, err := compose.ParseServiceConfig(project, service)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	capacityProviders := []aws.CapacityProvider{}
+	for _, provider := range config.Capacity {
+		capacityProviders = append(capacityProviders, aws.CapacityProvider{Provider: provider.Provider, Base: provider.Base, Weight: provider.Weight})
+	}
+	return aws.OneOffTask{
+		StackName:                  fmt.Sprintf("%s-%s", project.Name, service.Name),
+		ClusterResourceName:        cfntemplate.ClusterResourceName(service),
+		TaskDefinitionResourceName: cfntemplate.TaskDefinitionResourceName(service),
+		ContainerName:              cfntemplate.ContainerName(service),
+		SubnetExports:              cfntemplate.TaskSubnetExports(project, network),
+		AssignPublicIP:             cfntemplate.TasksHavePublicIP(network),
+		SecurityGroupExport:        cfntemplate.FargateTaskSecurityGroupExport(project),
+		Command:                    command,
+		CapacityProviders:          capacityProviders,
+	}
+}
+
+// Describe the resources of a deployed service behind a load balancer
+func serviceResources(project *composeTypes.Project, service *composeTypes.ServiceConfig) aws.ServiceResources {
+	return aws.ServiceResources{
+		StackName:               fmt.Sprintf("%s-%s", project.Name, service.Name),
+		ClusterResourceName:     cfntemplate.ClusterResourceName(service),
+		ServiceResourceName:     cfntemplate.ServiceResourceName(service),
+		TargetGroupResourceName: cfntemplate.TargetGroupResourceName(service),
+		ContainerName:           cfntemplate.ContainerName(service),
+	}
+}
+
+// Describe the resources of a deployed service shifting its traffic with CodeDeploy
+func blueGreenResources(project *composeTypes.Project, service *composeTypes.ServiceConfig) aws.BlueGreenResources {
+	return aws.BlueGreenResources{
+		StackName:                   fmt.Sprintf("%s-%s", project.Name, service.Name),
+		ClusterResourceName:         cfntemplate.ClusterResourceName(service),
+		ServiceResourceName:         cfntemplate.ServiceResourceName(service),
+		TaskDefinitionResourceName:  cfntemplate.TaskDefinitionResourceName(service),
+		ListenerResourceName:        cfntemplate.HttpsListenerResourceName(service),
+		ApplicationResourceName:     cfntemplate.CodeDeployApplicationResourceName(service),
+		DeploymentGroupResourceName: cfntemplate.DeploymentGroupResourceName(service),
+		ContainerName:               cfntemplate.ContainerName(service),
+		ContainerPort:               3000, // TODO: get the port from the compose file
+	}
+}
+
+// Deploy the stack of a service, after deploying and running the services it depends on with
+// `condition: service_completed_successfully`. Each service is deployed and each hook is run at most once.
+// Returns whether the stack of the service was deployed.
+func deployService(project *composeTypes.Project, network *cfntemplate.Network, services []composeTypes.ServiceConfig, service composeTypes.ServiceConfig, deployed map[string]bool, ran map[string]bool) bool {
+	if ok, done := deployed[service.Name]; done {
+		return ok
+	}
+	deployed[service.Name] = false
+
+	for _, hookName := range compose.PredeployHooks(project, &service) {
+		var hook *composeTypes.ServiceConfig
+		for i := range services {
+			if services[i].Name == hookName {
+				hook = &services[i]
+			}
+		}
+		if hook == nil {
+			// reported by compose.DeployedServices
+			continue
+		}
+		if !deployService(project, network, services, *hook, deployed, ran) {
+			log.Fatalf("[error] %s could not be deployed, not deploying %s\n", hookName, service.Name)
+		}
+		if ran[hookName] {
+			continue
+		}
+		ran[hookName] = true
+
+		log.Printf("[info] Running %s before deploying %s", hookName, service.Name)
+		runCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		exitCode, err := aws.RunTask(runCtx, oneOffTask(project, network, hook, hook.Command), os.Stdout)
+		stop()
+		if err != nil {
+			log.Fatalf("[error] Error running %s before deploying %s: %s\n", hookName, service.Name, err)
+		}
+		if exitCode != 0 {
+			log.Fatalf("[error] %s exited with code %d, not deploying %s\n", hookName, exitCode, service.Name)
+		}
+	}
+
+	config, err := compose.ParseServiceConfig(project, &service)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	imageTags := map[string]string{}
+	if !config.IsStatic() {
+		imageTags = build(project, &service)
+	}
+	y := cfntemplate.GenerateServiceTemplate(project, &service, imageTags, network)
+	if y == "" {
+		fmt.Println("No template to deploy.")
+		return false
+	}
+	stackName := fmt.Sprintf("%s-%s", project.Name, service.Name)
+	previousTaskDefinition := rollbackTarget(project, &service, config)
+	parameters := map[string]string{}
+	if config.CDN != nil {
+		parameters[cfntemplate.CDNCertificateParameter] = cdnCertificateARN(project, &service)
+	}
+	if config.IsBlueGreen() {
+		// the stack keeps what CodeDeploy deployed, the new task definition is deployed by CodeDeploy afterwards
+		state, err := aws.DescribeBlueGreenState(ctx, blueGreenResources(project, &service))
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		parameters[cfntemplate.TaskDefinitionInUseParameter] = state.TaskDefinition
+		parameters[cfntemplate.TargetGroupInUseParameter] = state.TargetGroup
+	}
+	if err := aws.StackDeployWithParameters(ctx, stackName, y, parameters); err != nil {
+		fmt.Printf("Error deploying stack: %s\n", err)
+		return false
+	}
+	if config.IsBlueGreen() {
+		if err := aws.BlueGreenDeploy(ctx, blueGreenResources(project, &service)); err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+	}
+	if config.IsStatic() {
+		uploadStatic(project, &service, config.CDN.Static)
+	}
+	if !runSmokeChecks(&service, config) {
+		if config.Deployment != nil && config.Deployment.RollbackOnSmoke {
+			if err := rollback(project, &service, config, previousTaskDefinition); err != nil {
+				log.Fatalf("[error] The smoke checks of %s failed, and rolling it back to task definition %s failed too: %s", service.Name, previousTaskDefinition, err)
+			}
+		}
+		log.Fatalf("[error] The smoke checks of %s failed", service.Name)
+	}
+	deployed[service.Name] = true
+	return true
+}
+
+// Get the deployed services to print the logs of, by name, or all of them when no name is given.
+// Static sites have no containers, so no logs.
+func logServices(project *composeTypes.Project, names []string) ([]composeTypes.ServiceConfig, error) {
+	deployed := []composeTypes.ServiceConfig{}
+	for _, service := range compose.DeployedServices(project) {
+		config, err := compose.ParseServiceConfig(project, &service)
+		if err != nil {
+			return nil, err
+		}
+		if !config.IsStatic() {
+			deployed = append(deployed, service)
+		}
+	}
+	if len(names) == 0 {
+		return deployed, nil
+	}
+	services := []composeTypes.ServiceConfig{}
+	for _, name := range names {
+		found := false
+		for _, service := range deployed {
+			if service.Name == name {
+				services = append(services, service)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("service %s is not deployed", name)
+		}
+	}
+	return services, nil
+}
+
+// Parse the --since flag of the logs command, a duration before now or a RFC 3339 time
+func parseSince(since string) (time.Time, error) {
+	if duration, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	if start, err := time.Parse(time.RFC3339, since); err == nil {
+		return start, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, expected a duration such as 10m or a RFC 3339 time", since)
+}
+
+// Print log lines prefixed with their service, padded to the longest name, in a colour per service when writing to a terminal
+func logPrinter(services []composeTypes.ServiceConfig) func(aws.LogEvent) {
+	colours := []string{"36", "33", "32", "35", "34", "31", "96", "93", "92", "95", "94", "91"}
+	width := 0
+	for _, service := range services {
+		width = max(width, len(service.Name))
+	}
+	stat, err := os.Stdout.Stat()
+	coloured := err == nil && stat.Mode()&os.ModeCharDevice != 0 && os.Getenv("NO_COLOR") == ""
+
+	prefixes := map[string]string{}
+	for i, service := range services {
+		prefix := fmt.Sprintf("%-*s |", width, service.Name)
+		if coloured {
+			prefix = fmt.Sprintf("\033[%sm%s\033[0m", colours[i%len(colours)], prefix)
+		}
+		prefixes[service.Name] = prefix
+	}
+	return func(event aws.LogEvent) {
+		fmt.Printf("%s %s %s\n", prefixes[event.Service], event.Timestamp.Local().Format("15:04:05"), event.Message)
+	}
 }
 
 func main() {
@@ -57,20 +338,16 @@ func main() {
 		Short: "Deploy your Docker Compose stack to AWS",
 		Run: func(cmd *cobra.Command, args []string) {
 			project := compose.Parse(composeFile)
-			bootstrap(project)
-
-			for _, service := range project.Services {
-				if service.Name == "client" {
-					imageTag := build(&service)
-					y := cfntemplate.GenerateServiceTemplate(project, &service, imageTag)
-					if y == "" {
-						fmt.Println("No template to deploy.")
-						return
-					}
-					if err := aws.StackDeploy(ctx, fmt.Sprintf("%s-%s", project.Name, service.Name), y); err != nil {
-						fmt.Printf("Error deploying stack: %s\n", err)
-					}
-				}
+			network := resolveNetwork(project)
+			rootDomains := bootstrap(project, network)
+			deployResources(project)
+			deployCDNCertificates(project, rootDomains)
+
+			services := compose.DeployedServices(project)
+			deployed := map[string]bool{}
+			ran := map[string]bool{}
+			for _, service := range services {
+				deployService(project, network, services, service, deployed, ran)
 			}
 		},
 	}
@@ -80,18 +357,36 @@ func main() {
 		Short: "SynthesizeCloudformation templates from a Compose file",
 		Run: func(cmd *cobra.Command, args []string) {
 			project := compose.Parse(composeFile)
-			bootstrapTemplate := cfntemplate.GenerateBootstrapTemplate(project)
+			network := resolveNetwork(project)
+			rootDomains := resolveRootDomains(project)
+			bootstrapTemplate := cfntemplate.GenerateBootstrapTemplate(project, network, rootDomains)
 			if err := os.WriteFile("bootstrap-template.yaml", []byte(bootstrapTemplate), 0644); err != nil {
 				log.Fatalf("Error writing bootstrap template to file: %s\n", err)
 			}
+			if resourcesTemplate := cfntemplate.GenerateResourcesTemplate(project); resourcesTemplate != "" {
+				if err := os.WriteFile("resources-template.yaml", []byte(resourcesTemplate), 0644); err != nil {
+					log.Fatalf("Error writing resources template to file: %s\n", err)
+				}
+			}
 
-			for _, service := range project.Services {
-				if service.Name == "client" {
-					imageTag := build(&service)
-					serviceTemplate := cfntemplate.GenerateServiceTemplate(project, &service, imageTag)
-					if err := os.WriteFile(fmt.Sprintf("%s-service-template.yaml", service.Name), []byte(serviceTemplate), 0644); err != nil {
-						log.Fatalf("Error writing service template to file: %s\n", err)
-					}
+			if cdnTemplate := cfntemplate.GenerateCDNCertificateTemplate(project, hostedZoneIDs(project, rootDomains)); cdnTemplate != "" {
+				if err := os.WriteFile("cdn-certificates-template.yaml", []byte(cdnTemplate), 0644); err != nil {
+					log.Fatalf("Error writing CDN certificates template to file: %s\n", err)
+				}
+			}
+
+			for _, service := range compose.DeployedServices(project) {
+				config, err := compose.ParseServiceConfig(project, &service)
+				if err != nil {
+					log.Fatalf("[error] %s", err)
+				}
+				imageTags := map[string]string{}
+				if !config.IsStatic() {
+					imageTags = build(project, &service)
+				}
+				serviceTemplate := cfntemplate.GenerateServiceTemplate(project, &service, imageTags, network)
+				if err := os.WriteFile(fmt.Sprintf("%s-service-template.yaml", service.Name), []byte(serviceTemplate), 0644); err != nil {
+					log.Fatalf("Error writing service template to file: %s\n", err)
 				}
 			}
 		},
@@ -102,13 +397,196 @@ func main() {
 		Short: "Bootstrap the cloud account with required resources needed for deployments, such as a Docker registry",
 		Run: func(cmd *cobra.Command, args []string) {
 			project := compose.Parse(composeFile)
-			bootstrap(project)
+			bootstrap(project, resolveNetwork(project))
+		},
+	}
+
+	planCmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show the stacks a deploy creates, the network of the tasks and the cost of its egress modes",
+		Run: func(cmd *cobra.Command, args []string) {
+			project := compose.Parse(composeFile)
+			printPlan(project)
+		},
+	}
+
+	var statusJSON bool
+	statusCmd := &cobra.Command{
+		Use:     "status",
+		Aliases: []string{"ps"},
+		Short:   "Show the deployed stacks, their tasks, image, target health and URL, and the recent runs of scheduled jobs",
+		Long: "Show the deployed stacks, their tasks, image, target health and URL, and the recent runs of scheduled jobs.\n" +
+			"Exits with code 1 when a stack failed, tasks are missing, targets are unhealthy or the last run of a job failed.",
+		Run: func(cmd *cobra.Command, args []string) {
+			project := compose.Parse(composeFile)
+			statuses := projectStatus(project)
+			if statusJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(statuses); err != nil {
+					log.Fatalf("[error] %s", err)
+				}
+			} else {
+				printStatus(statuses)
+			}
+			for _, status := range statuses {
+				if len(status.Problems) > 0 {
+					os.Exit(1)
+				}
+			}
+		},
+	}
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the status as JSON")
+
+	runCmd := &cobra.Command{
+		Use:   "run <service> [-- <command>...]",
+		Short: "Run a one-off task with the deployed task definition of a service, such as a database migration",
+		Long: "Run a one-off task with the deployed task definition of a service, such as a database migration.\n" +
+			"The logs of the task are streamed until it stops, and autodock exits with the exit code of its container.",
+		Example: "  autodock run api -- npm run migrate",
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			project := compose.Parse(composeFile)
+			service, err := project.GetService(args[0])
+			if err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+
+			// stop the task when autodock is interrupted
+			runCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			exitCode, err := aws.RunTask(runCtx, oneOffTask(project, resolveNetwork(project), &service, args[1:]), os.Stdout)
+			if err != nil {
+				log.Fatalf("[error] Error running a task for service %s: %s", service.Name, err)
+			}
+			log.Printf("[info] Task of service %s exited with code %d", service.Name, exitCode)
+			os.Exit(exitCode)
+		},
+	}
+
+	execCmd := &cobra.Command{
+		Use:   "exec <service> [-- <command>...]",
+		Short: "Open an interactive session in a running container of a service, a shell by default",
+		Long: "Open an interactive session in a running container of a service, a shell by default.\n" +
+			"The service needs exec: true in its x-autodock block, and the Session Manager plugin must be installed.",
+		Example: "  autodock exec api\n" +
+			"  autodock exec api -- ls -la /app",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			project := compose.Parse(composeFile)
+			service, err := project.GetService(args[0])
+			if err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+			config, err := compose.ParseServiceConfig(project, &service)
+			if err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+			if config.PrimaryDomain() == "" {
+				log.Fatalf("[error] service %s has no running tasks to open a session in, only services with a domain do", service.Name)
+			}
+			if !config.Exec {
+				log.Fatalf("[error] exec is not enabled for service %s, set exec: true in its x-autodock block and deploy", service.Name)
+			}
+			command := args[1:]
+			if len(command) == 0 {
+				command = []string{"sh"}
+			}
+			if err := aws.ExecCommand(ctx, serviceResources(project, &service), command); err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+		},
+	}
+
+	var follow bool
+	var since string
+	var filter string
+	var jsonOutput bool
+	logsCmd := &cobra.Command{
+		Use:   "logs [service...]",
+		Short: "Print the logs of deployed services, all of them by default",
+		Long: "Print the logs of deployed services, all of them by default, interleaved in chronological order.\n" +
+			"--filter takes a CloudWatch Logs filter pattern, such as a word to match.",
+		Example: "  autodock logs api worker --follow --since 10m --filter ERROR\n" +
+			"  autodock logs --json | jq .message",
+		Run: func(cmd *cobra.Command, args []string) {
+			project := compose.Parse(composeFile)
+			start, err := parseSince(since)
+			if err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+			services, err := logServices(project, args)
+			if err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+			sources := []aws.LogSource{}
+			for _, service := range services {
+				sources = append(sources, aws.LogSource{
+					Service:              service.Name,
+					StackName:            fmt.Sprintf("%s-%s", project.Name, service.Name),
+					LogGroupResourceName: cfntemplate.LogGroupResourceName(&service),
+				})
+			}
+
+			logsCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			handle := logPrinter(services)
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				handle = func(event aws.LogEvent) {
+					if err := encoder.Encode(event); err != nil {
+						log.Fatalf("[error] %s", err)
+					}
+				}
+			}
+			if err := aws.TailLogs(logsCtx, sources, start, filter, follow, handle); err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+		},
+	}
+	logsCmd.Flags().BoolVar(&follow, "follow", false, "Keep printing new logs until interrupted")
+	logsCmd.Flags().StringVar(&since, "since", "10m", "Print the logs since a duration ago (e.g. 10m, 2h) or a RFC 3339 time")
+	logsCmd.Flags().StringVar(&filter, "filter", "", "Only print the lines matching a CloudWatch Logs filter pattern")
+	logsCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print one JSON object per line")
+
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the x-autodock settings of a Compose file and report every problem",
+		Run: func(cmd *cobra.Command, args []string) {
+			project := compose.Parse(composeFile)
+			problems := compose.Validate(composeFile, project)
+			for _, problem := range problems {
+				fmt.Fprintln(os.Stderr, problem)
+			}
+			if len(problems) > 0 {
+				log.Fatalf("[error] Found %d problem(s) in %s", len(problems), composeFile)
+			}
+			fmt.Printf("%s is valid\n", composeFile)
+		},
+	}
+
+	schemaCmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the x-autodock settings, for editor completion",
+		Run: func(cmd *cobra.Command, args []string) {
+			schema, err := compose.JSONSchema()
+			if err != nil {
+				log.Fatalf("[error] Error generating JSON Schema: %s\n", err)
+			}
+			os.Stdout.Write(schema)
 		},
 	}
 
 	rootCmd.AddCommand(deployCmd)
 	rootCmd.AddCommand(synthCmd)
 	rootCmd.AddCommand(bootstrapCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(schemaCmd)
 
 	// TODO: remove this in prod
 	randomDevCmd := &cobra.Command{
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"autodock/aws"
+	"autodock/aws/cfntemplate"
+	"autodock/compose"
+	"log"
+
+	composeTypes "github.com/compose-spec/compose-go/v2/types"
+)
+
+// Resolve the network the services of a project run in, looking up the existing VPC it is set to use, so that the
+// templates are generated without calling AWS
+func resolveNetwork(project *composeTypes.Project) *cfntemplate.Network {
+	config, err := compose.ParseProjectConfig(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	var existing *cfntemplate.ExistingVPC
+	switch vpc := config.VPC; {
+	case vpc != nil && vpc.Lookup != nil:
+		found, err := aws.LookupVPC(ctx, vpc.Lookup.VPC, vpc.Lookup.PrivateSubnets, vpc.Lookup.PublicSubnets)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		log.Printf("[info] Using VPC %s with private subnets %v and public subnets %v", found.ID, found.PrivateSubnets, found.PublicSubnets)
+		existing = &cfntemplate.ExistingVPC{ID: found.ID, CIDR: found.CIDR, PrivateSubnets: found.PrivateSubnets, PublicSubnets: found.PublicSubnets}
+	case vpc != nil && vpc.ID != "":
+		existing = &cfntemplate.ExistingVPC{ID: vpc.ID, PrivateSubnets: vpc.PrivateSubnets, PublicSubnets: vpc.PublicSubnets}
+		if allowsWholeVPC(project) {
+			existing.CIDR, err = aws.LookupVPCCIDR(ctx, vpc.ID)
+			if err != nil {
+				log.Fatalf("[error] %s", err)
+			}
+		}
+	}
+	network, err := cfntemplate.NewNetwork(project, existing)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	return network
+}
+
+// Whether an internal service admits the whole VPC to its load balancer, which needs the range of the VPC
+func allowsWholeVPC(project *composeTypes.Project) bool {
+	for _, service := range compose.DeployedServices(project) {
+		config, err := compose.ParseServiceConfig(project, &service)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		if config.Visibility == "internal" && len(config.AllowCIDRs) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"autodock/aws/cfntemplate"
+	"autodock/compose"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	composeTypes "github.com/compose-spec/compose-go/v2/types"
+)
+
+// Hours in a month, as used by AWS pricing
+const hoursPerMonth = 730
+
+// Approximate on-demand prices in us-east-1, in USD
+const (
+	interfaceEndpointHourly = 0.01  // per availability zone
+	interfaceEndpointPerGB  = 0.01  // data processed
+	natGatewayHourly        = 0.045 // per gateway
+	natGatewayPerGB         = 0.045 // data processed
+	publicIPv4Hourly        = 0.005 // per address, of NAT gateways, NAT instances and tasks alike
+	crossZonePerGB          = 0.01  // each way, between availability zones
+	internetPerGB           = 0.09  // data transferred out to the internet, whatever the mode
+)
+
+// Hourly prices of the usual NAT instance types
+var natInstanceHourly = map[string]float64{
+	"t4g.nano":  0.0042,
+	"t4g.micro": 0.0084,
+	"t4g.small": 0.0168,
+	"t3.nano":   0.0052,
+	"t3.micro":  0.0104,
+	"t3.small":  0.0208,
+}
+
+// The monthly cost and trade-off of an egress mode
+type egressCost struct {
+	mode     string
+	fixed    string // per month, for the whole project
+	perGB    string // processed on the way out, on top of the internet data transfer
+	internet bool
+	notes    string
+}
+
+// Names of the APIs of the resources types, as reached through VPC endpoints
+var resourceAPINames = map[string]string{"s3": "S3", "sqs": "SQS", "sns": "SNS", "dynamodb": "DynamoDB"}
+
+// The cost of each egress mode for the network of a project, whose tasks call the APIs of its resources
+func egressCosts(vpc *compose.VPCConfig, azs int, endpoints int, apis []string) []egressCost {
+	reached := []string{"ECR", "CloudWatch Logs", "S3"}
+	for _, api := range apis {
+		if api != "s3" {
+			reached = append(reached, resourceAPINames[api])
+		}
+	}
+	natInstanceType := vpc.NATInstance()
+	natInstance := "unknown"
+	if hourly, ok := natInstanceHourly[natInstanceType]; ok {
+		natInstance = dollars((hourly + publicIPv4Hourly) * hoursPerMonth)
+	}
+	return []egressCost{
+		{
+			mode:  "endpoints",
+			fixed: dollars(float64(endpoints*azs) * interfaceEndpointHourly * hoursPerMonth),
+			perGB: rate(interfaceEndpointPerGB),
+			notes: fmt.Sprintf("%d interface endpoints in each zone. Tasks reach %s and %s, calls to any other API fail.", endpoints, strings.Join(reached[:len(reached)-1], ", "), reached[len(reached)-1]),
+		},
+		{
+			mode:     "nat-gateway",
+			fixed:    dollars(float64(azs) * (natGatewayHourly + publicIPv4Hourly) * hoursPerMonth),
+			perGB:    rate(natGatewayPerGB),
+			internet: true,
+			notes:    fmt.Sprintf("%d NAT gateways. Each zone keeps its egress when another one fails.", azs),
+		},
+		{
+			mode:     "single-nat-gateway",
+			fixed:    dollars((natGatewayHourly + publicIPv4Hourly) * hoursPerMonth),
+			perGB:    fmt.Sprintf("%s + %s cross-zone", rate(natGatewayPerGB), rate(crossZonePerGB)),
+			internet: true,
+			notes:    "Every zone loses its egress when the zone of the gateway fails.",
+		},
+		{
+			mode:     "nat-instance",
+			fixed:    natInstance,
+			perGB:    fmt.Sprintf("%s cross-zone", rate(crossZonePerGB)),
+			internet: true,
+			notes:    fmt.Sprintf("A %s instance to keep patched, with no failover and its bandwidth as the limit. For dev environments.", natInstanceType),
+		},
+		{
+			mode:     "public-ip",
+			fixed:    fmt.Sprintf("%s per task", dollars(publicIPv4Hourly*hoursPerMonth)),
+			perGB:    rate(0),
+			internet: true,
+			notes:    "Tasks run in the public subnets, their security group still only admits the load balancer.",
+		},
+	}
+}
+
+func dollars(amount float64) string {
+	return fmt.Sprintf("$%.2f", amount)
+}
+
+// A unit price, such as a price per GB, to the fraction of a cent
+func rate(amount float64) string {
+	return fmt.Sprintf("$%g", amount)
+}
+
+// Print what deploying a project creates: its stacks, its network, and the cost of the egress modes of its tasks
+func printPlan(project *composeTypes.Project) {
+	config, err := compose.ParseProjectConfig(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	resources, err := compose.Resources(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+
+	fmt.Println("Stacks:")
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "  %s-bootstrap\tnetwork, security groups, image repositories, hosted zones and certificates\n", project.Name)
+	if len(resources) > 0 {
+		fmt.Fprintf(writer, "  %s-resources\t%d resource(s)\n", project.Name, len(resources))
+	}
+	hasExec, hasCDN := false, false
+	for _, service := range compose.DeployedServices(project) {
+		serviceConfig, err := compose.ParseServiceConfig(project, &service)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		hasExec = hasExec || serviceConfig.Exec
+		kind := "one-off task"
+		switch {
+		case serviceConfig.Schedule != "":
+			kind = "scheduled job, " + serviceConfig.Schedule
+		case serviceConfig.IsStatic():
+			kind = "static site, https://" + serviceConfig.PrimaryDomain()
+		case serviceConfig.PrimaryDomain() != "":
+			kind = "service, https://" + serviceConfig.PrimaryDomain()
+		}
+		if serviceConfig.CDN != nil {
+			kind += " through CloudFront"
+			hasCDN = true
+		}
+		sidecars := []string{}
+		for _, container := range compose.TaskContainers(project, &service)[1:] {
+			sidecars = append(sidecars, container.Service.Name)
+		}
+		if len(sidecars) > 0 {
+			kind += ", with " + strings.Join(sidecars, ", ")
+		}
+		if serviceConfig.IsBlueGreen() {
+			kind += ", deployed blue/green by CodeDeploy"
+		}
+		fmt.Fprintf(writer, "  %s-%s\t%s\n", project.Name, service.Name, kind)
+	}
+	if hasCDN {
+		fmt.Fprintf(writer, "  %s\tCloudFront certificates, in us-east-1\n", cfntemplate.CDNCertificateStackName(project))
+	}
+	writer.Flush()
+
+	vpc := config.VPC
+	fmt.Println()
+	if vpc != nil && (vpc.Lookup != nil || vpc.ID != "") {
+		if vpc.ID != "" {
+			fmt.Printf("Network: the existing VPC %s\n", vpc.ID)
+		} else {
+			fmt.Println("Network: an existing VPC, looked up by tags when deploying")
+		}
+		if vpc.EgressMode() == "public-ip" {
+			fmt.Printf("Egress: public-ip, tasks run in the public subnets with a public IP at %s per task per month\n", dollars(publicIPv4Hourly*hoursPerMonth))
+		} else {
+			fmt.Println("Egress: routed by the existing VPC, through its own NAT gateways or endpoints")
+		}
+		return
+	}
+
+	privateCIDRs, _, err := vpc.SubnetCIDRs()
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	cidr := "10.0.0.0/16"
+	if vpc != nil && vpc.CIDR != "" {
+		cidr = vpc.CIDR
+	}
+	fmt.Printf("Network: a VPC %s in %d availability zones\n", cidr, len(privateCIDRs))
+	endpoints := 3 // ECR API, ECR Docker and CloudWatch Logs
+	if hasExec {
+		endpoints++
+	}
+	apis, err := compose.ResourceAPIs(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	for _, api := range apis {
+		// S3 and DynamoDB are reached through free gateway endpoints
+		if api == "sqs" || api == "sns" {
+			endpoints++
+		}
+	}
+	fmt.Printf("Egress: %s. Approximate us-east-1 prices per month, the selected mode marked with *:\n", vpc.EgressMode())
+	writer = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "  MODE\tFIXED\tPER GB\tINTERNET\tNOTES")
+	for _, cost := range egressCosts(vpc, len(privateCIDRs), endpoints, apis) {
+		marker := " "
+		if cost.mode == vpc.EgressMode() {
+			marker = "*"
+		}
+		internet := "no"
+		if cost.internet {
+			internet = "yes"
+		}
+		fmt.Fprintf(writer, "%s %s\t%s\t%s\t%s\t%s\n", marker, cost.mode, cost.fixed, cost.perGB, internet, cost.notes)
+	}
+	writer.Flush()
+	fmt.Printf("Data sent to the internet is charged %s per GB on top, in every mode. Set x-autodock.vpc.egress to change the mode.\n", rate(internetPerGB))
+}
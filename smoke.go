@@ -0,0 +1,80 @@
+package main
+
+import (
+	"autodock/aws"
+	"autodock/compose"
+	"autodock/utils"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	composeTypes "github.com/compose-spec/compose-go/v2/types"
+)
+
+// Run the smoke checks of a deployed service on its primary domain, one after the other, and log their results.
+// Returns whether they all passed.
+func runSmokeChecks(service *composeTypes.ServiceConfig, config *compose.ServiceConfig) bool {
+	passed := true
+	for _, check := range config.Smoke {
+		smoke := utils.SmokeCheck{
+			URL:      fmt.Sprintf("https://%s%s", config.PrimaryDomain(), check.Path),
+			Status:   200,
+			Timeout:  10 * time.Second,
+			Retries:  3,
+			Interval: 5 * time.Second,
+		}
+		if check.Status != 0 {
+			smoke.Status = check.Status
+		}
+		if check.Body != "" {
+			// checked by compose.ParseServiceConfig
+			smoke.Body = regexp.MustCompile(check.Body)
+		}
+		if check.Timeout != 0 {
+			smoke.Timeout = time.Duration(check.Timeout) * time.Second
+		}
+		if check.Retries != 0 {
+			smoke.Retries = check.Retries
+		}
+		if err := smoke.Run(ctx); err != nil {
+			log.Printf("[error] Smoke check of %s failed after %d attempts: %s", service.Name, smoke.Retries+1, err)
+			passed = false
+			continue
+		}
+		log.Printf("[info] Smoke check of %s passed: GET %s", service.Name, smoke.URL)
+	}
+	return passed
+}
+
+// The task definition a service runs before it is deployed, to roll back to when its smoke checks fail. Empty when
+// there is nothing to roll back to, such as on the first deploy.
+func rollbackTarget(project *composeTypes.Project, service *composeTypes.ServiceConfig, config *compose.ServiceConfig) string {
+	if config.Deployment == nil || !config.Deployment.RollbackOnSmoke {
+		return ""
+	}
+	taskDefinition, err := aws.CurrentTaskDefinition(ctx, serviceResources(project, service))
+	if err != nil {
+		log.Printf("[warn] Can't roll %s back if its smoke checks fail: %s", service.Name, err)
+	}
+	return taskDefinition
+}
+
+// Deploy the task definition a service ran before a deploy whose smoke checks failed
+func rollback(project *composeTypes.Project, service *composeTypes.ServiceConfig, config *compose.ServiceConfig, taskDefinition string) error {
+	if taskDefinition == "" {
+		log.Printf("[warn] Not rolling %s back: it had no previous task definition", service.Name)
+		return nil
+	}
+	var err error
+	if config.IsBlueGreen() {
+		err = aws.BlueGreenRollback(ctx, blueGreenResources(project, service), taskDefinition)
+	} else {
+		err = aws.RollbackService(ctx, serviceResources(project, service), taskDefinition)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("[info] Rolled %s back to task definition %s", service.Name, taskDefinition)
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"autodock/aws"
+	"autodock/aws/cfntemplate"
+	"autodock/compose"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	composeTypes "github.com/compose-spec/compose-go/v2/types"
+)
+
+// The status of a stack of the project, as reported by `autodock status`
+type stackStatus struct {
+	Stack    string             `json:"stack"`
+	Service  string             `json:"service,omitempty"`
+	Status   string             `json:"status"`
+	URL      string             `json:"url,omitempty"`
+	Tasks    *aws.ServiceStatus `json:"tasks,omitempty"`
+	Schedule string             `json:"schedule,omitempty"`
+	Runs     []aws.JobRun       `json:"runs,omitempty"` // newest first, over the last 7 days
+	Problems []string           `json:"problems"`
+}
+
+// Get the status of the bootstrap stack and of the stacks of the deployed services
+func projectStatus(project *composeTypes.Project) []stackStatus {
+	statuses := []stackStatus{stackStatusOf(fmt.Sprintf("%s-bootstrap", project.Name))}
+	resources, err := compose.Resources(project)
+	if err != nil {
+		log.Fatalf("[error] %s", err)
+	}
+	if len(resources) > 0 {
+		statuses = append(statuses, stackStatusOf(fmt.Sprintf("%s-resources", project.Name)))
+	}
+
+	for _, service := range compose.DeployedServices(project) {
+		config, err := compose.ParseServiceConfig(project, &service)
+		if err != nil {
+			log.Fatalf("[error] %s", err)
+		}
+		status := stackStatusOf(fmt.Sprintf("%s-%s", project.Name, service.Name))
+		status.Service = service.Name
+		status.Schedule = config.Schedule
+		if status.Status == "NOT_DEPLOYED" {
+			statuses = append(statuses, status)
+			continue
+		}
+
+		switch {
+		case config.Schedule != "":
+			runs, err := aws.JobRuns(ctx, status.Stack, cfntemplate.JobRunsLogGroupResourceName(&service), cfntemplate.ContainerName(&service), time.Now().AddDate(0, 0, -7))
+			if err != nil {
+				status.Problems = append(status.Problems, fmt.Sprintf("failed to get the runs of the job: %s", err))
+				break
+			}
+			status.Runs = runs
+			if len(runs) > 0 && runs[0].Failed() {
+				status.Problems = append(status.Problems, "the last run failed")
+			}
+		case config.IsStatic():
+			status.URL = fmt.Sprintf("https://%s", config.PrimaryDomain())
+		case config.PrimaryDomain() != "":
+			status.URL = fmt.Sprintf("https://%s%s", config.PrimaryDomain(), config.Path)
+			tasks, err := aws.DescribeService(ctx, serviceResources(project, &service))
+			if err != nil {
+				status.Problems = append(status.Problems, fmt.Sprintf("failed to describe the service: %s", err))
+				break
+			}
+			status.Tasks = tasks
+			if tasks.Running < tasks.Desired {
+				status.Problems = append(status.Problems, fmt.Sprintf("%d of %d tasks running", tasks.Running, tasks.Desired))
+			}
+			for _, target := range tasks.Targets {
+				if target.State == "unhealthy" || target.State == "unavailable" {
+					status.Problems = append(status.Problems, fmt.Sprintf("target %s is %s: %s", target.ID, target.State, target.Reason))
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Get the CloudFormation status of a stack, NOT_DEPLOYED when it doesn't exist
+func stackStatusOf(stackName string) stackStatus {
+	status := stackStatus{Stack: stackName, Problems: []string{}}
+	cfnStatus, err := aws.StackStatus(ctx, stackName)
+	if err != nil {
+		log.Fatalf("[error] [stack: %s] Error getting stack status: %s\n", stackName, err)
+	}
+	switch {
+	case cfnStatus == "":
+		status.Status = "NOT_DEPLOYED"
+		status.Problems = append(status.Problems, "the stack is not deployed")
+	case strings.Contains(cfnStatus, "ROLLBACK") || strings.HasSuffix(cfnStatus, "_FAILED"):
+		status.Status = cfnStatus
+		status.Problems = append(status.Problems, fmt.Sprintf("the stack is %s", cfnStatus))
+	default:
+		// complete, or being updated
+		status.Status = cfnStatus
+	}
+	return status
+}
+
+// Print statuses as a table, followed by the runs of scheduled jobs and the problems found
+func printStatus(statuses []stackStatus) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "STACK\tSTATUS\tTASKS\tIMAGE\tTARGETS\tURL")
+	for _, status := range statuses {
+		tasks, image, targets := "-", "-", "-"
+		if status.Tasks != nil {
+			tasks = fmt.Sprintf("%d/%d", status.Tasks.Running, status.Tasks.Desired)
+			if status.Tasks.Pending > 0 {
+				tasks += fmt.Sprintf(" (%d pending)", status.Tasks.Pending)
+			}
+			image = status.Tasks.Image[strings.LastIndex(status.Tasks.Image, "/")+1:]
+			if digest, found := strings.CutPrefix(status.Tasks.ImageDigest, "sha256:"); found {
+				image += "@" + digest[:min(12, len(digest))]
+			}
+			counts := []string{}
+			for _, state := range []string{"healthy", "initial", "unhealthy", "unavailable", "draining", "unused"} {
+				if count := status.Tasks.TargetStates()[state]; count > 0 {
+					counts = append(counts, fmt.Sprintf("%d %s", count, state))
+				}
+			}
+			if len(counts) > 0 {
+				targets = strings.Join(counts, ", ")
+			}
+		}
+		url := status.URL
+		if url == "" {
+			url = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", status.Stack, status.Status, tasks, image, targets, url)
+	}
+	writer.Flush()
+
+	for _, status := range statuses {
+		if status.Schedule == "" || status.Status == "NOT_DEPLOYED" {
+			continue
+		}
+		fmt.Printf("\nJob %s (%s), %d run(s) in the last 7 days:\n", status.Service, status.Schedule, len(status.Runs))
+		for _, run := range status.Runs {
+			result := "succeeded"
+			if run.Failed() {
+				result = "FAILED"
+				if run.ExitCode != nil {
+					result = fmt.Sprintf("FAILED with exit code %d", *run.ExitCode)
+				}
+				if run.Reason != "" {
+					result += ": " + run.Reason
+				}
+			}
+			fmt.Printf("  %s  %s\n", run.StoppedAt.Local().Format("2006-01-02 15:04"), result)
+		}
+	}
+
+	for _, status := range statuses {
+		for _, problem := range status.Problems {
+			fmt.Fprintf(os.Stderr, "[stack: %s] %s\n", status.Stack, problem)
+		}
+	}
+}
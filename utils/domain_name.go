@@ -3,19 +3,24 @@ package utils
 import (
 	"strings"
 
+	"golang.org/x/net/publicsuffix"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
+// The registrable domain of a domain name, according to the public suffix list.
+// Empty when there is none, such as for a public suffix itself.
 // Examples:
 // api.example.com -> example.com
 // example.com -> example.com
+// app.example.co.uk -> example.co.uk
+// co.uk -> ""
 func GetRootDomain(domainName string) string {
-	parts := strings.Split(domainName, ".")
-	if len(parts) < 2 {
-		return domainName
+	rootDomain, err := publicsuffix.EffectiveTLDPlusOne(domainName)
+	if err != nil {
+		return ""
 	}
-	return parts[len(parts)-2] + "." + parts[len(parts)-1]
+	return rootDomain
 }
 
 // Examples:
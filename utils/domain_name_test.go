@@ -10,9 +10,13 @@ func TestGetRootDomain(t *testing.T) {
 		{"api.example.com", "example.com"},
 		{"example.com", "example.com"},
 		{"foo.bar.example.com", "example.com"},
-		{"localhost", "localhost"},
+		{"app.example.co.uk", "example.co.uk"},
+		{"example.co.uk", "example.co.uk"},
+		{"api.eu.example.com.au", "example.com.au"},
+		{"localhost", ""},
 		{"", ""},
-		{"co.uk", "co.uk"},
+		{"co.uk", ""},
+		{"com", ""},
 	}
 
 	for _, test := range tests {
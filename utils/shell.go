@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Arguments that a shell reads as a single word without quotes
+var plainShellWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Join the arguments of a command into a command line that a shell splits back into the same arguments.
+// Examples:
+// [ls -la /app] -> ls -la /app
+// [sh -c echo hi] -> sh -c 'echo hi'
+// [grep $HOME *.log] -> grep '$HOME' '*.log'
+func ShellJoin(args []string) string {
+	words := []string{}
+	for _, arg := range args {
+		if plainShellWord.MatchString(arg) {
+			words = append(words, arg)
+			continue
+		}
+		words = append(words, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestShellJoin(t *testing.T) {
+	tests := []struct {
+		input    []string
+		expected string
+	}{
+		{[]string{"sh"}, "sh"},
+		{[]string{"ls", "-la", "/app"}, "ls -la /app"},
+		{[]string{"sh", "-c", "echo hi"}, "sh -c 'echo hi'"},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"echo", ""}, "echo ''"},
+		{[]string{"grep", "$HOME", "*.log"}, "grep '$HOME' '*.log'"},
+	}
+
+	for _, test := range tests {
+		result := ShellJoin(test.input)
+		if result != test.expected {
+			t.Errorf("ShellJoin(%q) = %q; want %q", test.input, result, test.expected)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Most bytes of a response body matched against the pattern of a smoke check
+const maxSmokeBody = 1 << 20
+
+// An HTTP check of a deployed URL
+type SmokeCheck struct {
+	URL      string
+	Status   int
+	Body     *regexp.Regexp // matched against the response body when set
+	Timeout  time.Duration  // of each request
+	Retries  int            // attempts after a failed one
+	Interval time.Duration  // between two attempts
+}
+
+// Request the URL until the response has the expected status and body, trying again at most Retries times. Redirects
+// are not followed, so that they can be checked too. Returns why the last attempt failed.
+func (c SmokeCheck) Run(ctx context.Context) error {
+	client := &http.Client{
+		Timeout: c.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.Interval):
+			}
+		}
+		if err = c.attempt(ctx, client); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c SmokeCheck) attempt(ctx context.Context, client *http.Client) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSmokeBody))
+	if err != nil {
+		return fmt.Errorf("failed to read the response of GET %s: %w", c.URL, err)
+	}
+	if response.StatusCode != c.Status {
+		return fmt.Errorf("GET %s returned %d instead of %d", c.URL, response.StatusCode, c.Status)
+	}
+	if c.Body != nil && !c.Body.Match(body) {
+		return fmt.Errorf("the response of GET %s doesn't match %s", c.URL, c.Body)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSmokeCheck(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			// unavailable until the third request
+			requests++
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"status": "ok"}`))
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		}
+	}))
+	defer server.Close()
+
+	check := func(path string, status int, body string, retries int) error {
+		smoke := SmokeCheck{URL: server.URL + path, Status: status, Timeout: time.Second, Retries: retries, Interval: time.Millisecond}
+		if body != "" {
+			smoke.Body = regexp.MustCompile(body)
+		}
+		return smoke.Run(context.Background())
+	}
+
+	if err := check("/healthz", 200, "", 1); err == nil || !strings.Contains(err.Error(), "returned 503 instead of 200") {
+		t.Errorf("expected the check to run out of retries, got %v", err)
+	}
+	if err := check("/healthz", 200, `"status":\s*"ok"`, 1); err != nil {
+		t.Errorf("expected the check to pass on its retry, got %v", err)
+	}
+	if err := check("/healthz", 200, `"status":\s*"degraded"`, 0); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("expected the body not to match, got %v", err)
+	}
+	if err := check("/old", 301, "", 0); err != nil {
+		t.Errorf("expected the redirect not to be followed, got %v", err)
+	}
+}